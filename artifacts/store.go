@@ -0,0 +1,353 @@
+// Package artifacts keeps compiled submissions on disk, keyed by a hash of
+// everything that went into compiling them, so identical source is not
+// compiled again on a rejudge or a duplicate submission. Each entry is a
+// copy of the compile workspace, with a manifest of the SHA-256 of its files
+// beside it that is checked before the entry is used. Entries are evicted
+// least recently used first once the store outgrows its budget; entries
+// being copied out are never evicted.
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+const (
+	tempPrefix = ".tmp-"
+	// manifestSuffix names the manifest of the entry <key>, <key>.sha256,
+	// in the format of sha256sum.
+	manifestSuffix = ".sha256"
+)
+
+type Store struct {
+	dir    string
+	budget int64 // bytes
+
+	mu      sync.Mutex
+	entries map[string]*entry // by key
+	size    int64
+}
+
+type entry struct {
+	size int64
+	used time.Time
+	pins int
+}
+
+// New opens the store in dir, indexing the entries already there by their
+// modification time, which is refreshed whenever an entry is used. Entries
+// that do not match their manifest are deleted.
+func New(dir string, budgetMB int) (*Store, error) {
+	s := &Store{
+		dir:     dir,
+		budget:  int64(budgetMB) << 20,
+		entries: make(map[string]*entry),
+	}
+	if err := sandbox.PrivateDir(dir); err != nil {
+		return nil, fmt.Errorf("creating artifact cache: %w", err)
+	}
+
+	prefixes, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("indexing artifact cache: %w", err)
+	}
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		keys, err := os.ReadDir(filepath.Join(dir, prefix.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("indexing artifact cache: %w", err)
+		}
+		for _, key := range keys {
+			path := filepath.Join(dir, prefix.Name(), key.Name())
+			if strings.HasPrefix(key.Name(), tempPrefix) {
+				// left over from an interrupted save
+				os.RemoveAll(path)
+				continue
+			}
+			if name, ok := strings.CutSuffix(key.Name(), manifestSuffix); ok {
+				if _, err := os.Lstat(filepath.Join(dir, prefix.Name(), name)); errors.Is(err, fs.ErrNotExist) {
+					// the entry was removed, or its save interrupted
+					os.Remove(path)
+				}
+				continue
+			}
+			if !validKey(key.Name()) || path != s.path(key.Name()) {
+				continue
+			}
+			if err := checkManifest(path); err != nil {
+				log.Printf("Warning: removing artifact %s: %v", key.Name(), err)
+				os.RemoveAll(path)
+				os.Remove(path + manifestSuffix)
+				continue
+			}
+			info, err := key.Info()
+			if err != nil {
+				return nil, fmt.Errorf("indexing artifact cache: %w", err)
+			}
+			size, err := dirSize(path)
+			if err != nil {
+				return nil, fmt.Errorf("indexing artifact cache: %w", err)
+			}
+			s.entries[key.Name()] = &entry{size: size, used: info.ModTime()}
+			s.size += size
+		}
+	}
+
+	s.mu.Lock()
+	s.evictLocked()
+	s.mu.Unlock()
+	log.Printf("Artifact cache %s: %d entries, %d MB", dir, len(s.entries), s.size>>20)
+	return s, nil
+}
+
+// Key hashes the parts that determine a compilation's output.
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		// Length-prefixed, so parts cannot run into each other.
+		binary.Write(hash, binary.LittleEndian, uint64(len(part)))
+		hash.Write([]byte(part))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil && strings.ToLower(key) == key
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// Restore copies the entry for key into dst and reports whether there was
+// one. A nil Store has no entries.
+func (s *Store) Restore(key, dst string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	e := s.entries[key]
+	if e == nil {
+		s.mu.Unlock()
+		return false
+	}
+	e.pins++
+	e.used = time.Now()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		e.pins--
+		s.evictLocked()
+		s.mu.Unlock()
+	}()
+
+	// Persist the recency for the index built after a restart.
+	now := time.Now()
+	os.Chtimes(s.path(key), now, now)
+
+	if err := checkManifest(s.path(key)); err != nil {
+		log.Printf("Warning: removing artifact %s: %v", key, err)
+		s.mu.Lock()
+		s.removeLocked(key)
+		s.mu.Unlock()
+		return false
+	}
+	if err := sandbox.CopyDir(s.path(key), dst); err != nil {
+		log.Printf("Error restoring artifact %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// Save stores a copy of src under key, unless there already is one. A nil
+// Store does nothing.
+func (s *Store) Save(key, src string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	_, ok := s.entries[key]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := sandbox.CopyDir(src, tmp); err != nil {
+		return fmt.Errorf("saving artifact: %w", err)
+	}
+	size, err := dirSize(tmp)
+	if err != nil {
+		return err
+	}
+	// The manifest goes in place first, so an entry is never without one.
+	defer os.Remove(tmp + manifestSuffix)
+	if err := writeManifest(tmp, tmp+manifestSuffix); err != nil {
+		return fmt.Errorf("saving artifact: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; ok {
+		// saved by a concurrent compilation of the same source
+		return nil
+	}
+	if err := os.Rename(tmp+manifestSuffix, path+manifestSuffix); err != nil {
+		return fmt.Errorf("saving artifact: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(path + manifestSuffix)
+		return fmt.Errorf("saving artifact: %w", err)
+	}
+	s.entries[key] = &entry{size: size, used: time.Now()}
+	s.size += size
+	s.evictLocked()
+	return nil
+}
+
+// evictLocked removes the least recently used unpinned entries until the
+// store fits its budget.
+func (s *Store) evictLocked() {
+	for s.size > s.budget {
+		var oldest string
+		for key, e := range s.entries {
+			if e.pins == 0 && (oldest == "" || e.used.Before(s.entries[oldest].used)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		if err := s.removeLocked(oldest); err != nil {
+			log.Printf("Error evicting artifact %s: %v", oldest, err)
+			return
+		}
+	}
+}
+
+// removeLocked deletes the entry for key and its manifest.
+func (s *Store) removeLocked(key string) error {
+	if err := os.RemoveAll(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	os.Remove(s.path(key) + manifestSuffix)
+	if e := s.entries[key]; e != nil {
+		s.size -= e.size
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// writeManifest records the SHA-256 of every file in dir at path.
+func writeManifest(dir, path string) error {
+	sums, err := hashDir(dir)
+	if err != nil {
+		return err
+	}
+	var manifest strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(sums)) {
+		fmt.Fprintf(&manifest, "%s  %s\n", sums[name], name)
+	}
+	return os.WriteFile(path, []byte(manifest.String()), 0600)
+}
+
+// checkManifest verifies that the entry at dir holds exactly the files in
+// its manifest, unchanged.
+func checkManifest(dir string) error {
+	data, err := os.ReadFile(dir + manifestSuffix)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]string)
+	for line := range strings.Lines(string(data)) {
+		sum, name, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "  ")
+		if !ok {
+			return errors.New("malformed manifest")
+		}
+		want[name] = sum
+	}
+	got, err := hashDir(dir)
+	if err != nil {
+		return err
+	}
+	if !maps.Equal(got, want) {
+		return errors.New("files do not match the manifest")
+	}
+	return nil
+}
+
+// hashDir returns the SHA-256 of the files in dir by their slash-separated
+// path relative to it.
+func hashDir(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	return sums, err
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,132 @@
+package artifacts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// workspace creates a compile workspace holding files.
+func workspace(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSaveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key("cpp", "g++ 13", "int main() {}")
+	if store.Restore(key, t.TempDir()) {
+		t.Fatal("restored an artifact never saved")
+	}
+
+	if err := store.Save(key, workspace(t, map[string]string{"main": "binary"})); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if !store.Restore(key, dst) {
+		t.Fatal("saved artifact not restored")
+	}
+	info, err := os.Stat(filepath.Join(dst, "main"))
+	if err != nil || info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("restored binary = %v, %v, want an executable", info, err)
+	}
+
+	reopened, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Restore(key, t.TempDir()) {
+		t.Error("artifact lost on reopening the store")
+	}
+}
+
+func TestChecksManifest(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, planted, kept := Key("tampered"), Key("planted"), Key("kept")
+	for _, key := range []string{tampered, kept} {
+		if err := store.Save(key, workspace(t, map[string]string{"main": "binary"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(store.path(tampered), "main"), []byte("other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if store.Restore(tampered, t.TempDir()) {
+		t.Error("restored an entry that does not match its manifest")
+	}
+	if _, err := os.Stat(store.path(tampered)); !os.IsNotExist(err) {
+		t.Errorf("tampered entry kept: %v", err)
+	}
+
+	// An entry without a manifest is dropped when the store is opened.
+	if err := os.MkdirAll(store.path(planted), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store.path(planted), "main"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.entries[planted] != nil || reopened.entries[kept] == nil {
+		t.Errorf("indexed %v, want only the saved entry", reopened.entries)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("cache directory = %v, %v, want mode 0700", info, err)
+	}
+}
+
+func TestKeySeparatesParts(t *testing.T) {
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("keys of different parts collide")
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	store, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := Key("a"), Key("b"), Key("c")
+	for _, key := range []string{a, b} {
+		if err := store.Save(key, workspace(t, map[string]string{"main": strings.Repeat("x", 400<<10)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Restore(a, t.TempDir()) // a is now more recent than b
+	if err := store.Save(c, workspace(t, map[string]string{"main": strings.Repeat("x", 400<<10)})); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{a: true, b: false, c: true} {
+		if got := store.Restore(key, t.TempDir()); got != want {
+			t.Errorf("%s kept = %v, want %v", key[:8], got, want)
+		}
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	if store.Restore(Key("a"), t.TempDir()) {
+		t.Error("nil store restored an artifact")
+	}
+	if err := store.Save(Key("a"), t.TempDir()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	DeadLetterSourceDLQ     = "dlq"
+	DeadLetterSourceRetry   = "retry"
+	DeadLetterSourceParking = "parking"
+)
+
+// Delivery is a single message handed to a consumer. Exactly one of Ack or
+// Nack must be called once the message has been handled.
+type Delivery interface {
+	Body() []byte
+	// EnqueuedAt is when the message was first published; retries keep
+	// it. Zero if the publisher did not record it.
+	EnqueuedAt() time.Time
+	Ack() error
+	// Nack dead-letters the message so it is retried later; reason is kept
+	// with the message for inspection.
+	Nack(reason string) error
+	// Reject parks the message without retrying it, for messages that can
+	// never succeed.
+	Reject(reason string) error
+}
+
+// Handler is called for every consumed message. It may return before the
+// message is settled, but should block while it cannot accept more work so
+// the broker does not over-deliver.
+type Handler func(ctx context.Context, d Delivery)
+
+type Broker interface {
+	Publish(ctx context.Context, body []byte) error
+	// Consume delivers messages to handle until ctx is cancelled.
+	Consume(ctx context.Context, handle Handler) error
+	// SetPrefetch limits how many unacknowledged messages are delivered at
+	// once; it follows the worker count.
+	SetPrefetch(count int) error
+
+	ListDeadLetters(source string, limit int) ([]DeadLetter, error)
+	ReplayDeadLetters(source string, filter DeadLetterFilter) (int, error)
+	PurgeDeadLetters(source string, filter DeadLetterFilter) (int, error)
+
+	Close() error
+}
+
+type DeadLetter struct {
+	SubmissionId      *int64  `json:"submission_id"`
+	Language          string  `json:"language"`
+	RetryCount        int32   `json:"retry_count"`
+	LastFailureReason string  `json:"last_failure_reason"`
+	FirstFailedAt     string  `json:"first_failed_at,omitempty"`
+	AgeSeconds        float64 `json:"age_seconds"`
+}
+
+// DeadLetterFilter selects messages by submission id. Messages whose body
+// cannot be parsed have no id and are only matched when All is set. Limit
+// caps how many messages one call acts on; 0 means no cap.
+type DeadLetterFilter struct {
+	SubmissionIds []int64
+	All           bool
+	Limit         int
+}
+
+func (f DeadLetterFilter) Matches(id *int64) bool {
+	if f.All {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	for _, want := range f.SubmissionIds {
+		if want == *id {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy controls how often a dead-lettered message is retried before it
+// is parked, and how long each attempt waits.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 5,
+		BaseDelay:  30 * time.Second,
+		MaxDelay:   30 * time.Minute,
+	}
+}
+
+// Delay returns the backoff before the given attempt (1-based):
+// base, 2*base, 4*base, ... capped at MaxDelay.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+type messageInfo struct {
+	SubmissionId *int64 `json:"submission_id"`
+	Language     string `json:"language"`
+}
+
+// MessageInfo extracts the submission id and language from a queued body.
+// Both are zero values when the body is not a valid submission.
+func MessageInfo(body []byte) (*int64, string) {
+	var info messageInfo
+	_ = json.Unmarshal(body, &info)
+	return info.SubmissionId, info.Language
+}
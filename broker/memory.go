@@ -0,0 +1,356 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process broker for local development, single-box
+// deployments and tests. When created with a path, every change is appended
+// to a log in that file, which is replayed and compacted on start; messages
+// that were in flight when the process stopped are delivered again, and
+// parked once that keeps happening.
+type Memory struct {
+	mu       sync.Mutex
+	ready    []*memoryMessage
+	delayed  []*memoryMessage
+	parked   []*memoryMessage
+	inflight map[uint64]*memoryMessage
+	nextId   uint64
+	retry    RetryPolicy
+	path     string
+	notify   chan struct{}
+
+	log      *os.File
+	logSize  int64 // bytes written since the last compaction
+	liveSize int64 // bytes of the bodies still queued
+}
+
+type memoryMessage struct {
+	Id            uint64    `json:"id"`
+	Body          []byte    `json:"body,omitempty"`
+	EnqueuedAt    time.Time `json:"enqueued_at"`
+	State         string    `json:"state,omitempty"`
+	Unsettled     int       `json:"unsettled,omitempty"` // deliveries cut short by a stop
+	RetryCount    int       `json:"retry_count"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	FirstFailedAt time.Time `json:"first_failed_at,omitzero"`
+	ReadyAt       time.Time `json:"ready_at,omitzero"`
+}
+
+// Message states, as recorded in the log.
+const (
+	stateReady    = "ready"
+	stateDelayed  = "delayed"
+	stateParked   = "parked"
+	stateInflight = "inflight"
+)
+
+func NewMemory(path string, retry RetryPolicy) (*Memory, error) {
+	m := &Memory{
+		inflight: make(map[uint64]*memoryMessage),
+		retry:    retry,
+		path:     path,
+		notify:   make(chan struct{}, 1),
+	}
+	if path == "" {
+		return m, nil
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded broker state from %s: %d ready, %d awaiting retry, %d parked", path, len(m.ready), len(m.delayed), len(m.parked))
+	return m, nil
+}
+
+func (m *Memory) Publish(ctx context.Context, body []byte) error {
+	m.mu.Lock()
+	m.nextId++
+	msg := &memoryMessage{
+		Id:         m.nextId,
+		Body:       append([]byte(nil), body...),
+		EnqueuedAt: time.Now(),
+		State:      stateReady,
+	}
+	m.ready = append(m.ready, msg)
+	m.liveSize += int64(len(msg.Body))
+	err := m.appendLocked(memoryRecord{Op: opPut, Msg: msg})
+	m.mu.Unlock()
+
+	m.wake()
+	return err
+}
+
+func (m *Memory) Consume(ctx context.Context, handle Handler) error {
+	log.Println("[*] Started consuming messages from in-memory queue")
+	for {
+		// Checked before every message, or a stop would drain the queue
+		// into a handler that can no longer judge it.
+		if ctx.Err() != nil {
+			log.Println("Context cancelled, stopping consumer loop")
+			return nil
+		}
+		msg, wait := m.next()
+		if msg != nil {
+			handle(ctx, &memoryDelivery{broker: m, msg: msg})
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Context cancelled, stopping consumer loop")
+			return nil
+		case <-m.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// next pops the first ready message, promoting delayed messages whose backoff
+// has elapsed. When nothing is ready it returns how long to wait.
+func (m *Memory) next() (*memoryMessage, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Minute
+	remaining := m.delayed[:0]
+	for _, msg := range m.delayed {
+		if !msg.ReadyAt.After(now) {
+			// Not logged: after a restart the elapsed ReadyAt promotes it
+			// again.
+			msg.ReadyAt = time.Time{}
+			msg.State = stateReady
+			m.ready = append(m.ready, msg)
+			continue
+		}
+		wait = min(wait, msg.ReadyAt.Sub(now))
+		remaining = append(remaining, msg)
+	}
+	m.delayed = remaining
+
+	if len(m.ready) == 0 {
+		return nil, wait
+	}
+	msg := m.ready[0]
+	m.ready = m.ready[1:]
+	m.inflight[msg.Id] = msg
+	msg.State = stateInflight
+	msg.Unsettled++
+	if err := m.appendLocked(stateRecord(msg)); err != nil {
+		log.Printf("Error persisting broker state: %v", err)
+	}
+	return msg, 0
+}
+
+func (m *Memory) wake() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memory) ack(msg *memoryMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inflight[msg.Id]; !ok {
+		return fmt.Errorf("message %d already settled", msg.Id)
+	}
+	delete(m.inflight, msg.Id)
+	m.liveSize -= int64(len(msg.Body))
+	return m.appendLocked(memoryRecord{Op: opDelete, Id: msg.Id})
+}
+
+func (m *Memory) reject(msg *memoryMessage, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inflight[msg.Id]; !ok {
+		return fmt.Errorf("message %d already settled", msg.Id)
+	}
+	delete(m.inflight, msg.Id)
+
+	if msg.FirstFailedAt.IsZero() {
+		msg.FirstFailedAt = time.Now()
+	}
+	msg.FailureReason = reason
+	msg.Unsettled = 0
+	msg.State = stateParked
+	m.parked = append(m.parked, msg)
+	log.Printf("Message rejected and parked: %s", reason)
+	return m.appendLocked(stateRecord(msg))
+}
+
+func (m *Memory) nack(msg *memoryMessage, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inflight[msg.Id]; !ok {
+		return fmt.Errorf("message %d already settled", msg.Id)
+	}
+	delete(m.inflight, msg.Id)
+
+	now := time.Now()
+	if msg.FirstFailedAt.IsZero() {
+		msg.FirstFailedAt = now
+	}
+	msg.FailureReason = reason
+	msg.Unsettled = 0
+
+	attempt := msg.RetryCount + 1
+	if attempt > m.retry.MaxRetries {
+		msg.State = stateParked
+		m.parked = append(m.parked, msg)
+		log.Printf("Message exceeded max retries (%d), parked. Last failure: %s", m.retry.MaxRetries, reason)
+		return m.appendLocked(stateRecord(msg))
+	}
+
+	msg.RetryCount = attempt
+	delay := m.retry.Delay(attempt)
+	msg.ReadyAt = now.Add(delay)
+	msg.State = stateDelayed
+	m.delayed = append(m.delayed, msg)
+	log.Printf("Scheduled retry %d/%d in %v. Failure: %s", attempt, m.retry.MaxRetries, delay, reason)
+
+	err := m.appendLocked(stateRecord(msg))
+	m.wake()
+	return err
+}
+
+func (m *Memory) deadLetters(source string) (*[]*memoryMessage, error) {
+	switch source {
+	case "", DeadLetterSourceDLQ, DeadLetterSourceRetry:
+		// Failed messages wait for their retry right away.
+		return &m.delayed, nil
+	case DeadLetterSourceParking:
+		return &m.parked, nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter source %q", source)
+	}
+}
+
+func (m *Memory) ListDeadLetters(source string, limit int) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages, err := m.deadLetters(source)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	for _, msg := range *messages {
+		if len(letters) >= limit {
+			break
+		}
+		id, language := MessageInfo(msg.Body)
+		letters = append(letters, DeadLetter{
+			SubmissionId:      id,
+			Language:          language,
+			RetryCount:        int32(msg.RetryCount),
+			LastFailureReason: msg.FailureReason,
+			FirstFailedAt:     msg.FirstFailedAt.UTC().Format(time.RFC3339),
+			AgeSeconds:        time.Since(msg.EnqueuedAt).Seconds(),
+		})
+	}
+	return letters, nil
+}
+
+func (m *Memory) ReplayDeadLetters(source string, filter DeadLetterFilter) (int, error) {
+	m.mu.Lock()
+	replayed, err := m.removeDeadLetters(source, filter, func(msg *memoryMessage) error {
+		msg.RetryCount = 0
+		msg.ReadyAt = time.Time{}
+		msg.State = stateReady
+		m.ready = append(m.ready, msg)
+		return m.appendLocked(stateRecord(msg))
+	})
+	m.mu.Unlock()
+
+	m.wake()
+	return replayed, err
+}
+
+func (m *Memory) PurgeDeadLetters(source string, filter DeadLetterFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removeDeadLetters(source, filter, func(msg *memoryMessage) error {
+		m.liveSize -= int64(len(msg.Body))
+		return m.appendLocked(memoryRecord{Op: opDelete, Id: msg.Id})
+	})
+}
+
+func (m *Memory) removeDeadLetters(source string, filter DeadLetterFilter, removed func(*memoryMessage) error) (int, error) {
+	messages, err := m.deadLetters(source)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var errs []error
+	remaining := (*messages)[:0]
+	for _, msg := range *messages {
+		id, _ := MessageInfo(msg.Body)
+		if !filter.Matches(id) || filter.Limit > 0 && count >= filter.Limit {
+			remaining = append(remaining, msg)
+			continue
+		}
+		if err := removed(msg); err != nil {
+			errs = append(errs, err)
+		}
+		count++
+	}
+	*messages = remaining
+	return count, errors.Join(errs...)
+}
+
+// SetPrefetch is a no-op: Consume hands out one message at a time and the
+// handler blocks while no worker is free.
+func (m *Memory) SetPrefetch(count int) error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return nil
+	}
+	err := m.log.Close()
+	m.log = nil
+	return err
+}
+
+type memoryDelivery struct {
+	broker *Memory
+	msg    *memoryMessage
+}
+
+func (d *memoryDelivery) Body() []byte {
+	return d.msg.Body
+}
+
+func (d *memoryDelivery) EnqueuedAt() time.Time {
+	return d.msg.EnqueuedAt
+}
+
+func (d *memoryDelivery) Ack() error {
+	return d.broker.ack(d.msg)
+}
+
+func (d *memoryDelivery) Nack(reason string) error {
+	return d.broker.nack(d.msg, reason)
+}
+
+func (d *memoryDelivery) Reject(reason string) error {
+	return d.broker.reject(d.msg, reason)
+}
@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// The memory broker's state file is a log of JSON records, one per line.
+// A message's body is written once when it is published; later changes
+// only record its state, so an operation costs the size of a record rather
+// than of the whole queue. The log is compacted on start and whenever most
+// of it describes messages that are gone.
+const (
+	opNext   = "next"   // Id is the last message id handed out
+	opPut    = "put"    // Msg is a message, body included
+	opState  = "state"  // Msg is a message's new state, without its body
+	opDelete = "delete" // Id is a settled or purged message
+
+	compactMinSize = 16 << 20
+)
+
+type memoryRecord struct {
+	Op  string         `json:"op"`
+	Id  uint64         `json:"id,omitempty"`
+	Msg *memoryMessage `json:"msg,omitempty"`
+}
+
+func stateRecord(msg *memoryMessage) memoryRecord {
+	state := *msg
+	state.Body = nil
+	return memoryRecord{Op: opState, Msg: &state}
+}
+
+// appendLocked adds a record to the log, compacting it once it has grown
+// well beyond the messages it describes.
+func (m *Memory) appendLocked(record memoryRecord) error {
+	if m.log == nil {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := m.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("persisting broker state: %w", err)
+	}
+	m.logSize += int64(len(data) + 1)
+
+	if m.logSize > compactMinSize && m.logSize > 2*m.liveSize {
+		return m.compactLocked()
+	}
+	return nil
+}
+
+// load replays the log into the queues. A message that was in flight is
+// delivered again, unless it was in flight at more stops in a row than it
+// could be retried, which suggests it brings the engine down; it is parked
+// instead.
+func (m *Memory) load() error {
+	data, err := os.ReadFile(m.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading broker state: %w", err)
+	}
+
+	messages := make(map[uint64]*memoryMessage)
+	order := make(map[uint64]int) // position of a message's latest record
+	if err := m.replay(data, messages, order); err != nil {
+		return fmt.Errorf("parsing broker state %s: %w", m.path, err)
+	}
+
+	for _, msg := range messages {
+		if msg.State == stateInflight {
+			msg.State = stateReady
+			if msg.Unsettled > m.retry.MaxRetries {
+				msg.State = stateParked
+				msg.FailureReason = fmt.Sprintf("in flight at %d stops in a row", msg.Unsettled)
+				log.Printf("Message %d was in flight at %d stops in a row, parked", msg.Id, msg.Unsettled)
+			}
+		}
+		switch msg.State {
+		case stateReady:
+			m.ready = append(m.ready, msg)
+		case stateDelayed:
+			m.delayed = append(m.delayed, msg)
+		case stateParked:
+			m.parked = append(m.parked, msg)
+		}
+		m.liveSize += int64(len(msg.Body))
+	}
+	for _, queue := range [][]*memoryMessage{m.ready, m.delayed, m.parked} {
+		slices.SortFunc(queue, func(a, b *memoryMessage) int {
+			return order[a.Id] - order[b.Id]
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.compactLocked()
+}
+
+func (m *Memory) replay(data []byte, messages map[uint64]*memoryMessage, order map[uint64]int) error {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				log.Printf("Warning: ignoring a partly written record at the end of %s", m.path)
+			}
+			return nil
+		}
+
+		var record memoryRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("record %d: %w", line+1, err)
+		}
+		switch record.Op {
+		case opNext:
+			m.nextId = max(m.nextId, record.Id)
+		case opPut:
+			messages[record.Msg.Id] = record.Msg
+			order[record.Msg.Id] = line
+			m.nextId = max(m.nextId, record.Msg.Id)
+		case opState:
+			if msg := messages[record.Msg.Id]; msg != nil {
+				record.Msg.Body = msg.Body
+				messages[msg.Id] = record.Msg
+				order[msg.Id] = line
+			}
+		case opDelete:
+			delete(messages, record.Id)
+		default:
+			return fmt.Errorf("record %d: unknown op %q", line+1, record.Op)
+		}
+	}
+}
+
+// compactLocked rewrites the log with one record per message, in queue
+// order, and keeps appending to the new file.
+func (m *Memory) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".broker-*")
+	if err != nil {
+		return fmt.Errorf("compacting broker state: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+
+	err = encoder.Encode(memoryRecord{Op: opNext, Id: m.nextId})
+	for _, queue := range [][]*memoryMessage{m.ready, m.delayed, m.parked} {
+		for _, msg := range queue {
+			if err == nil {
+				err = encoder.Encode(memoryRecord{Op: opPut, Msg: msg})
+			}
+		}
+	}
+	for _, msg := range m.inflight {
+		if err == nil {
+			err = encoder.Encode(memoryRecord{Op: opPut, Msg: msg})
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting broker state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting broker state: %w", err)
+	}
+
+	if m.log != nil {
+		m.log.Close()
+	}
+	m.log = tmp
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	m.logSize = info.Size()
+	return nil
+}
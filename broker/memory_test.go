@@ -0,0 +1,109 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openMemory(t *testing.T, path string) *Memory {
+	t.Helper()
+	m, err := NewMemory(path, RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// deliver hands out the next ready message without a consumer loop.
+func deliver(t *testing.T, m *Memory) *memoryDelivery {
+	t.Helper()
+	msg, _ := m.next()
+	if msg == nil {
+		t.Fatal("no message ready")
+	}
+	return &memoryDelivery{broker: m, msg: msg}
+}
+
+func TestMemoryPersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.log")
+	m := openMemory(t, path)
+	for _, body := range []string{`{"submission_id":1}`, `{"submission_id":2}`, `{"submission_id":3}`} {
+		if err := m.Publish(context.Background(), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deliver(t, m).Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(t, m).Nack("boom"); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, m) // in flight when the engine stops
+	m.Close()
+
+	m = openMemory(t, path)
+	if len(m.ready) != 1 || string(m.ready[0].Body) != `{"submission_id":3}` {
+		t.Fatalf("ready = %v, want the in-flight message", m.ready)
+	}
+	if len(m.delayed) != 1 || m.delayed[0].RetryCount != 1 || m.delayed[0].FailureReason != "boom" {
+		t.Fatalf("delayed = %+v, want the nacked message with its retry", m.delayed)
+	}
+	if m.nextId != 3 {
+		t.Errorf("next id = %d, want 3", m.nextId)
+	}
+
+	// State changes do not rewrite bodies.
+	info, _ := os.Stat(path)
+	before := info.Size()
+	deliver(t, m).Reject("bad")
+	info, _ = os.Stat(path)
+	if grown := info.Size() - before; grown <= 0 || grown > 512 {
+		t.Errorf("reject grew the log by %d bytes, want one small record", grown)
+	}
+}
+
+func TestMemoryConsumeStopsWhenCancelled(t *testing.T) {
+	m := openMemory(t, "")
+	for range 5 {
+		if err := m.Publish(context.Background(), []byte(`{"submission_id":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := 0
+	m.Consume(ctx, func(ctx context.Context, d Delivery) {
+		handled++
+		cancel()
+		d.Nack("engine shutting down")
+	})
+	if handled != 1 {
+		t.Errorf("%d messages handled, want only the one before the cancel", handled)
+	}
+}
+
+func TestMemoryParksMessagesInFlightAtEveryStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.log")
+	m := openMemory(t, path)
+	if err := m.Publish(context.Background(), []byte(`{"submission_id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for stop := 1; stop <= 3; stop++ {
+		deliver(t, m)
+		m.Close()
+		m = openMemory(t, path)
+	}
+
+	if len(m.ready) != 0 || len(m.parked) != 1 {
+		t.Fatalf("%d ready and %d parked, want the message parked", len(m.ready), len(m.parked))
+	}
+	if reason := m.parked[0].FailureReason; !strings.Contains(reason, "3 stops") {
+		t.Errorf("failure reason = %q", reason)
+	}
+}
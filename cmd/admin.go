@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/judgenot0/judge-deamon/broker"
+	"github.com/judgenot0/judge-deamon/utils"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+type deadLetterRequest struct {
+	Source        string  `json:"source"`
+	SubmissionIds []int64 `json:"submission_ids"`
+	All           bool    `json:"all"`
+	Limit         int     `json:"limit"`
+}
+
+// requireEngineKey rejects requests that do not carry the engine key as a
+// bearer token.
+func (s *Server) requireEngineKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.currentConfig().EngineKey)) != 1 {
+			utils.SendResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit := defaultDeadLetterLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			utils.SendResponse(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxDeadLetterLimit)
+	}
+
+	letters, err := s.manager.ListDeadLetters(r.URL.Query().Get("source"), limit)
+	if err != nil {
+		utils.SendResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.SendResponse(w, http.StatusOK, letters)
+}
+
+func (s *Server) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeadLetterRequest(w, r)
+	if !ok {
+		return
+	}
+
+	replayed, err := s.manager.ReplayDeadLetters(req.Source, req.filter())
+	if err != nil {
+		utils.SendResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	utils.SendResponse(w, http.StatusOK, map[string]int{"replayed": replayed})
+}
+
+func (s *Server) handlePurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeadLetterRequest(w, r)
+	if !ok {
+		return
+	}
+
+	purged, err := s.manager.PurgeDeadLetters(req.Source, req.filter())
+	if err != nil {
+		utils.SendResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	utils.SendResponse(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+func decodeDeadLetterRequest(w http.ResponseWriter, r *http.Request) (deadLetterRequest, bool) {
+	defer r.Body.Close()
+
+	var req deadLetterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendResponse(w, http.StatusBadRequest, "Invalid request payload")
+		return req, false
+	}
+	switch req.Source {
+	case "", broker.DeadLetterSourceDLQ, broker.DeadLetterSourceRetry, broker.DeadLetterSourceParking:
+	default:
+		utils.SendResponse(w, http.StatusBadRequest, "source must be \"dlq\", \"retry\" or \"parking\"")
+		return req, false
+	}
+	if req.Limit < 0 {
+		utils.SendResponse(w, http.StatusBadRequest, "Invalid limit")
+		return req, false
+	}
+	if !req.All && len(req.SubmissionIds) == 0 {
+		utils.SendResponse(w, http.StatusBadRequest, "submission_ids is required unless all is true")
+		return req, false
+	}
+	return req, true
+}
+
+// filter acts on at most limit messages per request, defaultDeadLetterLimit
+// unless given, so a large queue is worked through in pages.
+func (req deadLetterRequest) filter() broker.DeadLetterFilter {
+	limit := defaultDeadLetterLimit
+	if req.Limit > 0 {
+		limit = min(req.Limit, maxDeadLetterLimit)
+	}
+	return broker.DeadLetterFilter{SubmissionIds: req.SubmissionIds, All: req.All, Limit: limit}
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/judgenot0/judge-deamon/utils"
+)
+
+type cancelRequest struct {
+	SubmissionIds []int64 `json:"submission_ids"`
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendResponse(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(req.SubmissionIds) == 0 {
+		utils.SendResponse(w, http.StatusBadRequest, "submission_ids is required")
+		return
+	}
+
+	utils.SendResponse(w, http.StatusOK, s.scheduler.Cancel(req.SubmissionIds))
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/judgenot0/judge-deamon/scheduler"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -110,10 +111,57 @@ func (m *SystemMetrics) Collect() {
 	}()
 }
 
+// PoolMetrics exports the state of the worker pool, so an alert can fire
+// when boxes are lost to quarantine.
+type PoolMetrics struct {
+	Target        *prometheus.GaugeVec
+	Boxes         *prometheus.GaugeVec
+	ResetFailures *prometheus.CounterVec
+	nodeID        string
+}
+
+func newPoolMetrics(nodeID string) *PoolMetrics {
+	return &PoolMetrics{
+		nodeID: nodeID,
+		Target: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "judge_pool_target_boxes",
+			Help: "Configured number of sandbox boxes (worker_count)",
+		}, []string{"node_id"}),
+		Boxes: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "judge_pool_boxes",
+			Help: "Sandbox boxes by state: idle, busy, resetting or quarantined",
+		}, []string{"node_id", "state"}),
+		ResetFailures: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "judge_pool_reset_failures_total",
+			Help: "Failed sandbox box resets",
+		}, []string{"node_id"}),
+	}
+}
+
+func (m *PoolMetrics) Collect(scheduler *scheduler.Scheduler) {
+	go func() {
+		var prevFailures int
+		for {
+			stats := scheduler.PoolStats()
+			m.Target.WithLabelValues(m.nodeID).Set(float64(stats.Target))
+			m.Boxes.WithLabelValues(m.nodeID, "idle").Set(float64(stats.Idle))
+			m.Boxes.WithLabelValues(m.nodeID, "busy").Set(float64(stats.Busy))
+			m.Boxes.WithLabelValues(m.nodeID, "resetting").Set(float64(stats.Resetting))
+			m.Boxes.WithLabelValues(m.nodeID, "quarantined").Set(float64(stats.Quarantined))
+			m.ResetFailures.WithLabelValues(m.nodeID).Add(float64(stats.ResetFailures - prevFailures))
+			prevFailures = stats.ResetFailures
+
+			time.Sleep(5 * time.Second)
+		}
+	}()
+}
+
 func (s *Server) RegisterMetrics() {
 	node := s.RegisterNode()
 	sysMetrics := newSystemMetrics(node)
 	sysMetrics.Collect()
+	poolMetrics := newPoolMetrics(node)
+	poolMetrics.Collect(s.scheduler)
 }
 
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
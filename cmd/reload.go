@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/utils"
+)
+
+// currentConfig returns the configuration in effect after the last reload.
+// s.config keeps the startup values for settings that need a restart.
+func (s *Server) currentConfig() *config.Config {
+	return s.scheduler.Handler().Config
+}
+
+// Reload re-reads the configuration and applies it without a restart.
+// Running jobs finish under the settings they started with.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	previous := s.currentConfig()
+	if cfg.Broker != previous.Broker || cfg.BrokerFile != previous.BrokerFile ||
+		cfg.RabbitMQURL != previous.RabbitMQURL || cfg.QueueName != previous.QueueName ||
+		cfg.HttpPort != previous.HttpPort || cfg.ServerEndpoint != previous.ServerEndpoint {
+		log.Println("Warning: broker, queue, HTTP port and server endpoint changes only take effect after a restart")
+	}
+	if cfg.Sandbox != previous.Sandbox || cfg.SandboxRoot != previous.SandboxRoot || cfg.BoxIdMin != previous.BoxIdMin ||
+		cfg.BoxIdMax != previous.BoxIdMax || cfg.BoxLockDir != previous.BoxLockDir {
+		log.Println("Warning: sandbox backend, root, box id range and lock directory changes only take effect after a restart")
+		cfg.Sandbox, cfg.SandboxRoot = previous.Sandbox, previous.SandboxRoot
+		cfg.BoxIdMin, cfg.BoxIdMax = previous.BoxIdMin, previous.BoxIdMax
+		cfg.BoxLockDir = previous.BoxLockDir
+	}
+
+	if cfg.MaxRetries != previous.MaxRetries || cfg.RetryBaseDelay != previous.RetryBaseDelay ||
+		cfg.RetryMaxDelay != previous.RetryMaxDelay {
+		log.Println("Warning: retry count and delay changes only take effect after a restart")
+		cfg.MaxRetries, cfg.RetryBaseDelay, cfg.RetryMaxDelay = previous.MaxRetries, previous.RetryBaseDelay, previous.RetryMaxDelay
+	}
+
+	if cfg.TestcaseCacheDir != previous.TestcaseCacheDir || cfg.TestcaseCacheMB != previous.TestcaseCacheMB {
+		log.Println("Warning: testcase cache directory and size changes only take effect after a restart")
+		cfg.TestcaseCacheDir, cfg.TestcaseCacheMB = previous.TestcaseCacheDir, previous.TestcaseCacheMB
+	}
+	if cfg.ArtifactCacheDir != previous.ArtifactCacheDir || cfg.ArtifactCacheMB != previous.ArtifactCacheMB {
+		log.Println("Warning: artifact cache directory and size changes only take effect after a restart")
+		cfg.ArtifactCacheDir, cfg.ArtifactCacheMB = previous.ArtifactCacheDir, previous.ArtifactCacheMB
+	}
+
+	if err := s.scheduler.Reload(cfg); err != nil {
+		return fmt.Errorf("applying configuration: %w", err)
+	}
+	if err := s.manager.SetPrefetch(cfg.Prefetch()); err != nil {
+		return fmt.Errorf("updating prefetch: %w", err)
+	}
+
+	log.Printf("Configuration reloaded, %d workers", cfg.WorkerCount)
+	return nil
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(); err != nil {
+		log.Printf("Reload failed: %v", err)
+		utils.SendResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.SendResponse(w, http.StatusOK, "")
+}
@@ -6,4 +6,10 @@ func (s *Server) registerRoutes(mux *http.ServeMux) {
 	mux.Handle("POST /submit", http.HandlerFunc(s.handleSubmit))
 	mux.Handle("POST /run", http.HandlerFunc(s.handlerRun))
 	mux.Handle("GET /metrics", http.HandlerFunc(s.handleMetrics))
+
+	mux.Handle("GET /admin/dead-letters", s.requireEngineKey(s.handleListDeadLetters))
+	mux.Handle("POST /admin/dead-letters/replay", s.requireEngineKey(s.handleReplayDeadLetters))
+	mux.Handle("POST /admin/dead-letters/purge", s.requireEngineKey(s.handlePurgeDeadLetters))
+	mux.Handle("POST /admin/cancel", s.requireEngineKey(s.handleCancel))
+	mux.Handle("POST /admin/reload", s.requireEngineKey(s.handleReload))
 }
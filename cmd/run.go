@@ -1,40 +1,22 @@
 package cmd
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
-	"os/exec"
-	"time"
 
-	"github.com/judgenot0/judge-deamon/handlers"
 	"github.com/judgenot0/judge-deamon/scheduler"
 	"github.com/judgenot0/judge-deamon/structs"
 	"github.com/judgenot0/judge-deamon/utils"
 )
 
-func run(ctx context.Context, boxId int, runReq *structs.Submission, handler *handlers.Handler) string {
-	if runReq.Language == "" {
-		return "ce"
-	}
-	if runReq.SourceCode == "" {
-		return "ce"
-	}
-
-	runner := scheduler.GetRunner(runReq.Language)
-	if runner == nil {
-		return "ce"
-	}
-
-	verdict, err := runner.Compile(ctx, boxId, runReq)
-	if err != nil {
-		return "ce"
-	}
-
-	verdict = runner.Run(ctx, boxId, runReq, handler)
-	return verdict.Result
+type runResponse struct {
+	Result       string                `json:"result"`
+	RuntimeError *structs.RuntimeError `json:"runtime_error,omitempty"`
+	WrongAnswer  *structs.WrongAnswer  `json:"wrong_answer,omitempty"`
+	Score        *float64              `json:"score,omitempty"`
+	Tests        []structs.TestResult  `json:"tests,omitempty"`
 }
 
 func (s *Server) handlerRun(w http.ResponseWriter, r *http.Request) {
@@ -48,45 +30,55 @@ func (s *Server) handlerRun(w http.ResponseWriter, r *http.Request) {
 		utils.SendResponse(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	if errs := scheduler.ValidateSubmission(&runReq, s.currentConfig(), false); errs != nil {
+		sendValidationError(w, errs)
+		return
+	}
 
-	select {
-	case <-s.ctx.Done():
+	if s.ctx.Err() != nil {
 		utils.SendResponse(w, http.StatusServiceUnavailable, "Server shutting down")
-	case worker := <-s.scheduler.WorkChannel:
-		defer func() {
-			cleanupCmd := exec.Command("isolate", fmt.Sprintf("--box-id=%d", worker.Id), "--cg", "--cleanup")
-			if err := cleanupCmd.Run(); err != nil {
-				log.Printf("Error cleaning up sandbox %d: %v", worker.Id, err)
-			}
-
-			initCmd := exec.Command("isolate", fmt.Sprintf("--box-id=%d", worker.Id), "--cg", "--init")
-			if err := initCmd.Run(); err != nil {
-				log.Printf("Error reinitializing sandbox %d: %v", worker.Id, err)
-			}
+		return
+	}
 
-			s.scheduler.WorkChannel <- worker
-		}()
+	handler := s.scheduler.Handler()
+	release, err := handler.Testcases.Resolve(r.Context(), handler.Config, &runReq)
+	if err != nil {
+		log.Printf("Error fetching testcases: %v", err)
+		utils.SendResponse(w, http.StatusBadGateway, "Failed to fetch testcases")
+		return
+	}
+	defer release()
 
-		var panicked bool
-		var verdict string
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					panicked = true
-				}
-			}()
-			verdict = run(r.Context(), worker.Id, &runReq, s.scheduler.Handler)
+	var panicked bool
+	var verdict structs.Verdict
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = true
+			}
 		}()
+		verdict, err = s.scheduler.Judge(r.Context(), handler, &runReq, handler.Config.RunWaitTimeout)
+	}()
 
-		if panicked {
-			utils.SendResponse(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-
-		utils.SendResponse(w, http.StatusOK, map[string]string{
-			"result": verdict,
-		})
-	case <-time.After(30 * time.Second):
+	if panicked {
+		utils.SendResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if errors.Is(err, scheduler.ErrNoWorkers) {
 		utils.SendResponse(w, http.StatusServiceUnavailable, "No workers available")
+		return
 	}
+	if err != nil {
+		// The client went away while the run waited.
+		log.Printf("Run request abandoned: %v", err)
+		return
+	}
+
+	utils.SendResponse(w, http.StatusOK, runResponse{
+		Result:       verdict.Result,
+		RuntimeError: verdict.RuntimeError,
+		WrongAnswer:  verdict.WrongAnswer,
+		Score:        verdict.Score,
+		Tests:        verdict.Tests,
+	})
 }
@@ -5,25 +5,27 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/judgenot0/judge-deamon/broker"
 	"github.com/judgenot0/judge-deamon/config"
-	"github.com/judgenot0/judge-deamon/queue"
 	"github.com/judgenot0/judge-deamon/scheduler"
 )
 
 type Server struct {
 	config     *config.Config
-	manager    *queue.Queue
+	manager    broker.Broker
 	scheduler  *scheduler.Scheduler
 	httpServer *http.Server
 	ctx        context.Context
+	reloadMu   sync.Mutex
 }
 
-func NewServer(config *config.Config, queue *queue.Queue, scheduler *scheduler.Scheduler, ctx context.Context) *Server {
+func NewServer(config *config.Config, manager broker.Broker, scheduler *scheduler.Scheduler, ctx context.Context) *Server {
 	return &Server{
 		config:    config,
-		manager:   queue,
+		manager:   manager,
 		scheduler: scheduler,
 		ctx:       ctx,
 	}
@@ -54,9 +56,9 @@ func (s *Server) Listen(port string) error {
 	s.httpServer = &http.Server{
 		Addr:         addr,
 		Handler:      wrapedMux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  s.config.HTTPReadTimeout,
+		WriteTimeout: s.config.HTTPWriteTimeout,
+		IdleTimeout:  s.config.HTTPIdleTimeout,
 	}
 
 	errChan := make(chan error, 1)
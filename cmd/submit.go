@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 
+	"github.com/judgenot0/judge-deamon/scheduler"
+	"github.com/judgenot0/judge-deamon/structs"
 	"github.com/judgenot0/judge-deamon/utils"
 )
 
@@ -16,10 +19,27 @@ func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.manager.QueueMessage(submission)
+	var parsed structs.Submission
+	if err := json.Unmarshal(submission, &parsed); err != nil {
+		utils.SendResponse(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if errs := scheduler.ValidateSubmission(&parsed, s.currentConfig(), true); errs != nil {
+		sendValidationError(w, errs)
+		return
+	}
+
+	err = s.manager.Publish(r.Context(), submission)
 	if err != nil {
 		utils.SendResponse(w, http.StatusBadRequest, "Failed to Queue submission")
 		return
 	}
 	utils.SendResponse(w, http.StatusOK, "")
 }
+
+func sendValidationError(w http.ResponseWriter, errs scheduler.ValidationError) {
+	utils.SendResponse(w, http.StatusBadRequest, map[string]any{
+		"message": "Invalid submission",
+		"errors":  errs,
+	})
+}
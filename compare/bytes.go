@@ -0,0 +1,235 @@
+package compare
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// byteSource yields the bytes to compare along with where each came from in
+// the original file.
+type byteSource interface {
+	next() (b byte, line, column int, err error)
+}
+
+// byteReader yields a file unchanged.
+type byteReader struct {
+	r      *bufio.Reader
+	line   int
+	column int
+}
+
+func newByteReader(r io.ReaderAt) *byteReader {
+	return &byteReader{r: newBufferedReader(r), line: 1}
+}
+
+func (s *byteReader) next() (byte, int, int, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, s.line, s.column + 1, err
+	}
+	if b == '\n' {
+		line, column := s.line, s.column+1
+		s.line++
+		s.column = 0
+		return b, line, column, nil
+	}
+	s.column++
+	return b, s.line, s.column, nil
+}
+
+// pendingBufLen is the longest whitespace run a lineReader keeps in memory.
+const pendingBufLen = 4096
+
+// lineReader yields a file with whitespace at line ends and blank lines
+// removed, and a final line break added if missing. A run of whitespace is
+// only known to be trailing once the line ends, so it is held back until
+// then; runs too long to keep in memory are re-read from the file instead.
+type lineReader struct {
+	src    io.ReaderAt
+	r      *bufio.Reader
+	offset int64 // of the next byte in r
+	line   int
+	column int // of the last byte read from r
+
+	pendingOffset int64 // whitespace run not yet known to be trailing
+	pendingLen    int64
+	pendingColumn int
+	pending       []byte // the run itself while it fits in pendingBufLen
+
+	replay       []byte // whitespace being emitted
+	replayOffset int64  // and what is left of it in the file
+	replayLen    int64
+	replayColumn int
+	replayBuf    []byte
+
+	hasContent bool // the current line has emitted something
+	done       bool
+}
+
+func newLineReader(r io.ReaderAt) *lineReader {
+	return &lineReader{
+		src:       r,
+		r:         newBufferedReader(r),
+		line:      1,
+		pending:   make([]byte, 0, pendingBufLen),
+		replayBuf: make([]byte, 0, pendingBufLen),
+	}
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func (s *lineReader) next() (byte, int, int, error) {
+	for {
+		if len(s.replay) == 0 && s.replayLen > 0 {
+			n := min(s.replayLen, pendingBufLen)
+			s.replay = s.replayBuf[:n]
+			if _, err := s.src.ReadAt(s.replay, s.replayOffset); err != nil {
+				return 0, s.line, s.replayColumn, err
+			}
+			s.replayOffset += n
+			s.replayLen -= n
+		}
+		if len(s.replay) > 0 {
+			b, column := s.replay[0], s.replayColumn
+			s.replay = s.replay[1:]
+			s.replayColumn++
+			return b, s.line, column, nil
+		}
+		if s.done {
+			return 0, s.line, s.column + 1, io.EOF
+		}
+
+		b, err := s.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			s.done = true
+			if s.hasContent {
+				s.hasContent = false
+				return '\n', s.line, s.column + 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, s.line, s.column + 1, err
+		}
+		s.offset++
+		s.column++
+
+		switch {
+		case b == '\n':
+			line, column := s.line, s.column
+			s.line++
+			s.column = 0
+			s.pendingLen = 0
+			s.pending = s.pending[:0]
+			if s.hasContent {
+				s.hasContent = false
+				return '\n', line, column, nil
+			}
+		case isSpace(b):
+			if s.pendingLen == 0 {
+				s.pendingOffset = s.offset - 1
+				s.pendingColumn = s.column
+			}
+			s.pendingLen++
+			if len(s.pending) < cap(s.pending) {
+				s.pending = append(s.pending, b)
+			}
+		default:
+			s.hasContent = true
+			if s.pendingLen > 0 {
+				// The whitespace was inside the line after all.
+				s.replayColumn = s.pendingColumn
+				if s.pendingLen <= int64(len(s.pending)) {
+					s.replay = append(s.replayBuf[:0], s.pending...)
+				} else {
+					s.replayOffset, s.replayLen = s.pendingOffset, s.pendingLen
+				}
+				s.pendingLen = 0
+				s.pending = s.pending[:0]
+				if err := s.r.UnreadByte(); err != nil {
+					return 0, s.line, s.column, err
+				}
+				s.offset--
+				s.column--
+				continue
+			}
+			return b, s.line, s.column, nil
+		}
+	}
+}
+
+func compareBytes(output, expected byteSource) (*Mismatch, error) {
+	// The bytes of the line matched so far, the same in both, are kept to
+	// show before the mismatch; at most contextLen plus one, which is
+	// enough for withContext to tell it was cut.
+	var before []byte
+	for {
+		o, line, column, outErr := output.next()
+		e, expectedLine, _, expErr := expected.next()
+		outEOF := errors.Is(outErr, io.EOF)
+		expEOF := errors.Is(expErr, io.EOF)
+		if outErr != nil && !outEOF {
+			return nil, fmt.Errorf("reading output: %w", outErr)
+		}
+		if expErr != nil && !expEOF {
+			return nil, fmt.Errorf("reading expected output: %w", expErr)
+		}
+
+		switch {
+		case outEOF && expEOF:
+			return nil, nil
+		case outEOF || expEOF || o != e:
+			m := &Mismatch{Line: line, ExpectedLine: expectedLine, Column: column}
+			out := excerpt(o, outErr, output)
+			exp := excerpt(e, expErr, expected)
+			m.Output = withContext(string(before), "", out)
+			m.Expected = withContext(string(before), "", exp)
+			switch {
+			case outEOF:
+				m.Kind = MissingOutput
+				m.Reason = "output ended early, expected " + strconv.Quote(exp)
+			case expEOF:
+				m.Kind = ExtraOutput
+				m.Reason = "unexpected extra output " + strconv.Quote(out)
+			default:
+				m.Kind = Different
+				m.Reason = fmt.Sprintf("expected %q, found %q", m.Expected, m.Output)
+			}
+			return m, nil
+		}
+
+		if o == '\n' {
+			before = before[:0]
+			continue
+		}
+		if len(before) > contextLen {
+			before = append(before[:0], before[1:]...)
+		}
+		before = append(before, o)
+	}
+}
+
+// excerpt returns the text starting at first, the byte that differed.
+func excerpt(first byte, err error, rest byteSource) string {
+	if err != nil {
+		return ""
+	}
+	buf := []byte{first}
+	for len(buf) < excerptLen && first != '\n' {
+		b, _, _, err := rest.next()
+		if err != nil || b == '\n' {
+			break
+		}
+		buf = append(buf, b)
+	}
+	return string(buf)
+}
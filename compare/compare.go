@@ -0,0 +1,145 @@
+// Package compare checks a program's output against the expected output.
+// Both are streamed, so memory use does not grow with the size of the files
+// or the length of their lines, except in the unordered modes.
+package compare
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+type Mode int
+
+const (
+	// Exact compares byte for byte.
+	Exact Mode = iota
+	// Lines ignores whitespace at the end of lines and blank lines, like
+	// diff -Z -B.
+	Lines
+	// Tokens compares whitespace-separated tokens and ignores how they are
+	// split into lines.
+	Tokens
+	// Float compares tokens line by line, numbers within Options.Tolerance
+	// and other tokens exactly. Blank lines are ignored.
+	Float
+	// FloatTokens is Float ignoring how tokens are split into lines.
+	FloatTokens
+	// CaseInsensitive is Tokens ignoring ASCII case.
+	CaseInsensitive
+	// YesNo is CaseInsensitive for answers that must be YES or NO.
+	YesNo
+	// Integers is Tokens comparing integers of any size by value.
+	Integers
+	// UnorderedLines accepts the expected lines in any order, ignoring
+	// whitespace at the end of lines and blank lines.
+	UnorderedLines
+	// UnorderedTokens accepts the expected tokens in any order and split
+	// into lines in any way.
+	UnorderedTokens
+)
+
+type Options struct {
+	Mode Mode
+	// Tolerance applies to the float modes.
+	Tolerance Tolerance
+}
+
+// Kind classifies a Mismatch.
+type Kind string
+
+const (
+	Different        Kind = "different"
+	ExtraOutput      Kind = "extra_output"
+	MissingOutput    Kind = "missing_output"
+	TokenCount       Kind = "token_count" // a line has more or fewer tokens than expected
+	OutsideTolerance Kind = "outside_tolerance"
+)
+
+// Mismatch describes the first difference found.
+type Mismatch struct {
+	Line         int // in the output, 1-based
+	ExpectedLine int
+	Column       int // byte column in the output line, 1-based; byte modes only
+	Token        int // index of the token in the output, 1-based; token modes only
+	Output       string
+	Expected     string // excerpts of both at the mismatch, after some of the line before it
+	Kind         Kind
+	Reason       string
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("line %d: %s", m.Line, m.Reason)
+}
+
+// excerptLen bounds the excerpts kept in a Mismatch, and contextLen the
+// part of them taken from before the mismatch.
+const (
+	excerptLen = 64
+	contextLen = 16
+)
+
+// withContext puts before, the end of the line up to a mismatch, in front
+// of the excerpt s, separated by sep.
+func withContext(before, sep, s string) string {
+	if before == "" {
+		return s
+	}
+	if len(before) > contextLen {
+		before = "..." + before[len(before)-contextLen:]
+	}
+	return before + sep + s
+}
+
+// Files compares the file at outputPath against the file at expectedPath. A
+// nil Mismatch means they match.
+func Files(outputPath, expectedPath string, opts Options) (*Mismatch, error) {
+	output, err := os.Open(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	expected, err := os.Open(expectedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer expected.Close()
+
+	return Readers(output, expected, opts)
+}
+
+// Readers compares output against expected. A nil Mismatch means they
+// match.
+func Readers(output, expected io.ReaderAt, opts Options) (*Mismatch, error) {
+	switch opts.Mode {
+	case Exact:
+		return compareBytes(newByteReader(output), newByteReader(expected))
+	case Lines:
+		return compareBytes(newLineReader(output), newLineReader(expected))
+	case Tokens:
+		return compareTokens(newTokenReader(output, false), newTokenReader(expected, false), tokenRule{})
+	case Float:
+		return compareTokens(newTokenReader(output, true), newTokenReader(expected, true), floatRule(opts.Tolerance))
+	case FloatTokens:
+		return compareTokens(newTokenReader(output, false), newTokenReader(expected, false), floatRule(opts.Tolerance))
+	case CaseInsensitive:
+		return compareTokens(newTokenReader(output, false), newTokenReader(expected, false), caseInsensitiveRule)
+	case YesNo:
+		return compareTokens(newTokenReader(output, false), newTokenReader(expected, false), yesNoRule)
+	case Integers:
+		return compareTokens(newTokenReader(output, false), newTokenReader(expected, false), integerRule)
+	case UnorderedLines:
+		return compareUnorderedLines(output, expected)
+	case UnorderedTokens:
+		return compareUnorderedTokens(output, expected)
+	default:
+		return nil, fmt.Errorf("unknown comparison mode %d", opts.Mode)
+	}
+}
+
+func newBufferedReader(r io.ReaderAt) *bufio.Reader {
+	return bufio.NewReaderSize(io.NewSectionReader(r, 0, math.MaxInt64), 64*1024)
+}
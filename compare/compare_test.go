@@ -0,0 +1,176 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             Mode
+		output, expected string
+		match            bool
+	}{
+		{"exact identical", Exact, "1 2\n3\n", "1 2\n3\n", true},
+		{"exact trailing space", Exact, "1 2 \n3\n", "1 2\n3\n", false},
+		{"exact missing newline", Exact, "1 2\n3", "1 2\n3\n", false},
+		{"exact empty", Exact, "", "", true},
+
+		{"lines trailing space", Lines, "1 2  \t\n3\n", "1 2\n3\n", true},
+		{"lines crlf", Lines, "1 2\r\n3\r\n", "1 2\n3\n", true},
+		{"lines blank lines", Lines, "\n1 2\n\n\n3\n\n", "1 2\n3\n", true},
+		{"lines whitespace-only line", Lines, "1 2\n   \n3\n", "1 2\n3\n", true},
+		{"lines missing newline", Lines, "1 2\n3", "1 2\n3\n", true},
+		{"lines inner space differs", Lines, "1  2\n3\n", "1 2\n3\n", false},
+		{"lines leading space differs", Lines, " 1 2\n3\n", "1 2\n3\n", false},
+		{"lines inner tab kept", Lines, "1\t2\n", "1\t2\n", true},
+		{"lines different text", Lines, "1 2\n4\n", "1 2\n3\n", false},
+		{"lines joined", Lines, "1 2 3\n", "1 2\n3\n", false},
+		{"lines extra output", Lines, "1\n2\n", "1\n", false},
+		{"lines empty vs blank", Lines, "\n\n", "", true},
+
+		{"tokens split differently", Tokens, "1\n2\n3", "1 2 3\n", true},
+		{"tokens differ", Tokens, "1 2 4", "1 2 3", false},
+		{"tokens prefix", Tokens, "12", "1", false},
+		{"tokens missing", Tokens, "1 2", "1 2 3", false},
+
+		{"float within epsilon", Float, "0.3333333 1\n", "0.3333334 1\n", true},
+		{"float outside epsilon", Float, "0.34\n", "0.33\n", false},
+		{"float words", Float, "YES 0.5\n", "YES 0.5\n", true},
+		{"float word vs number", Float, "1.0\n", "abc\n", false},
+		{"float blank lines ignored", Float, "1.0\n\n2.0\n", "1.0\n2.0\n", true},
+		{"float line break differs", Float, "1.0\n2.0\n", "1.0 2.0\n", false},
+		{"float tokens line break ignored", FloatTokens, "1.0\n2.0\n", "1.0 2.0\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (m == nil) != tt.match {
+				t.Errorf("mismatch = %+v, want match %v", m, tt.match)
+			}
+		})
+	}
+}
+
+func TestMismatchPosition(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             Mode
+		output, expected string
+		want             Mismatch
+	}{
+		{
+			"exact", Exact, "ab\ncd\nef\n", "ab\ncx\nef\n",
+			Mismatch{Line: 2, ExpectedLine: 2, Column: 2, Output: "cd", Expected: "cx", Kind: Different},
+		},
+		{
+			"lines reports original line", Lines, "ab\n\n\ncd\n", "ab\nce\n",
+			Mismatch{Line: 4, ExpectedLine: 2, Column: 2, Output: "cd", Expected: "ce", Kind: Different},
+		},
+		{
+			"lines replayed whitespace column", Lines, "a  b\n", "a  c\n",
+			Mismatch{Line: 1, ExpectedLine: 1, Column: 4, Output: "a  b", Expected: "a  c", Kind: Different},
+		},
+		{
+			"exact cuts context", Exact, "0123456789abcdefghij\n", "0123456789abcdefghiJ\n",
+			Mismatch{Line: 1, ExpectedLine: 1, Column: 20, Output: "...3456789abcdefghij", Expected: "...3456789abcdefghiJ", Kind: Different},
+		},
+		{
+			"tokens", Tokens, "1 2\n3 5\n", "1 2 3 4\n",
+			Mismatch{Line: 2, ExpectedLine: 1, Token: 4, Output: "3 5", Expected: "3 4", Kind: Different},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				t.Fatal("no mismatch found")
+			}
+			got := *m
+			got.Reason = ""
+			if got != tt.want {
+				t.Errorf("mismatch = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMismatchReasons(t *testing.T) {
+	tests := []struct {
+		mode             Mode
+		output, expected string
+		kind             Kind
+		reason           string
+	}{
+		{Lines, "1\n", "1\n2\n", MissingOutput, "output ended early"},
+		{Lines, "1\n2\n", "1\n", ExtraOutput, "unexpected extra output"},
+		{Tokens, "1", "1 2", MissingOutput, "output ended early"},
+		{Tokens, "1 2", "1", ExtraOutput, "unexpected extra output"},
+		{Float, "1\n2\n", "1 2\n", TokenCount, "unexpected line break"},
+		{Float, "1 2\n", "1\n2\n", TokenCount, "expected a line break"},
+		{Float, "1.5\n", "1.4\n", OutsideTolerance, "outside the tolerance"},
+		{Float, "YES\n", "NO\n", Different, "expected"},
+		{Float, "1.5\n", "NO\n", Different, "expected"},
+	}
+
+	for _, tt := range tests {
+		m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || m.Kind != tt.kind || !strings.Contains(m.Reason, tt.reason) {
+			t.Errorf("Readers(%q, %q) = %+v, want %s with reason %q", tt.output, tt.expected, m, tt.kind, tt.reason)
+		}
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	// Far beyond bufio.Scanner's 64 KB token limit.
+	numbers := strings.Repeat("123456789 ", 1<<20)
+	long := strings.Repeat("x", 4<<20)
+
+	for _, mode := range []Mode{Exact, Lines, Tokens, Float, FloatTokens} {
+		m, err := Readers(strings.NewReader(numbers+"\n"), strings.NewReader(numbers+"\n"), Options{Mode: mode})
+		if err != nil || m != nil {
+			t.Errorf("mode %d: long line: mismatch %+v, err %v", mode, m, err)
+		}
+		m, err = Readers(strings.NewReader(long+"a"), strings.NewReader(long+"b"), Options{Mode: mode})
+		if err != nil || m == nil {
+			t.Errorf("mode %d: long token: no mismatch, err %v", mode, err)
+		}
+		m, err = Readers(strings.NewReader(long), strings.NewReader(long), Options{Mode: mode})
+		if err != nil || m != nil {
+			t.Errorf("mode %d: long token: mismatch %+v, err %v", mode, m, err)
+		}
+	}
+}
+
+func TestLongWhitespaceRun(t *testing.T) {
+	spaces := strings.Repeat(" \t", pendingBufLen)
+	tests := []struct {
+		output, expected string
+		match            bool
+	}{
+		{"a" + spaces + "b\n", "a" + spaces + "b\n", true},
+		{"a" + spaces + "b\n", "a" + spaces + " b\n", false},
+		{"a" + spaces + "\n", "a\n", true},
+	}
+	for i, tt := range tests {
+		m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: Lines})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (m == nil) != tt.match {
+			t.Errorf("case %d: mismatch = %+v, want match %v", i, m, tt.match)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type FloatMode int
+
+const (
+	// Hybrid accepts |a-b| <= Absolute * (1 + max(|a|, |b|)), the engine's
+	// original rule.
+	Hybrid FloatMode = iota
+	// Absolute accepts |a-b| <= Absolute.
+	Absolute
+	// Relative accepts |a-b| <= Relative * |expected|.
+	Relative
+	// AbsoluteOrRelative accepts a number within either tolerance.
+	AbsoluteOrRelative
+)
+
+// DefaultTolerance is used for a tolerance left at zero.
+const DefaultTolerance = 1e-6
+
+// slack absorbs rounding in the subtraction itself, so that e.g. 0.3 and
+// 0.300001 are within 1e-6 of each other.
+const slack = 1e-15
+
+type Tolerance struct {
+	Mode     FloatMode
+	Absolute float64
+	Relative float64
+}
+
+// parseNumber accepts decimal numbers with an optional exponent, and nan
+// and inf in any case with an optional sign. Hex floats, underscores and
+// other forms strconv allows are not numbers and compare as text.
+func parseNumber(token string) (float64, bool) {
+	s := strings.TrimLeft(token, "+-")
+	if len(token)-len(s) > 1 {
+		return 0, false
+	}
+	switch strings.ToLower(s) {
+	case "nan":
+		// printf prints -nan for NaNs with the sign bit set
+		return math.NaN(), true
+	case "inf", "infinity":
+		if token[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	}
+
+	digits, dot, exponent := 0, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.' && !dot && !exponent:
+			dot = true
+		case (c == 'e' || c == 'E') && !exponent && digits > 0:
+			exponent = true
+			if i+1 < len(s) && (s[i+1] == '+' || s[i+1] == '-') {
+				i++
+			}
+			if i+1 >= len(s) {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+
+	// Out of range values come back as ±Inf or 0, which is what they mean.
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); !ok || numErr.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	if token[0] == '-' {
+		v = -v
+	}
+	return v, true
+}
+
+// Equal reports whether output is within tolerance of expected. NaN only
+// matches NaN, an infinity only the same infinity, and -0 equals 0.
+func (t Tolerance) Equal(output, expected float64) bool {
+	switch {
+	case math.IsNaN(output) || math.IsNaN(expected):
+		return math.IsNaN(output) && math.IsNaN(expected)
+	case math.IsInf(output, 0) || math.IsInf(expected, 0):
+		return output == expected
+	}
+
+	absolute, relative := t.Absolute, t.Relative
+	if absolute == 0 {
+		absolute = DefaultTolerance
+	}
+	if relative == 0 {
+		relative = DefaultTolerance
+	}
+
+	diff := math.Abs(output - expected)
+	switch t.Mode {
+	case Absolute:
+		return diff <= absolute+slack
+	case Relative:
+		return diff <= relative*math.Abs(expected)+slack
+	case AbsoluteOrRelative:
+		return diff <= math.Max(absolute, relative*math.Abs(expected))+slack
+	default:
+		// Relative error for large values, absolute error otherwise
+		return diff <= absolute*(1+math.Max(math.Abs(output), math.Abs(expected)))
+	}
+}
+
+// floatRule compares tokens as numbers within tolerance, or as text if
+// neither is a number.
+func floatRule(tolerance Tolerance) tokenRule {
+	return tokenRule{
+		equal: func(output, expected string) bool {
+			outputVal, outputIsNumber := parseNumber(output)
+			expectedVal, expectedIsNumber := parseNumber(expected)
+
+			if !outputIsNumber && !expectedIsNumber {
+				return output == expected
+			}
+			if !outputIsNumber || !expectedIsNumber {
+				return false
+			}
+			return tolerance.Equal(outputVal, expectedVal)
+		},
+		explain: func(output, expected string) (Kind, string, bool) {
+			_, outputIsNumber := parseNumber(output)
+			_, expectedIsNumber := parseNumber(expected)
+			if !outputIsNumber || !expectedIsNumber {
+				return "", "", false
+			}
+			return OutsideTolerance, fmt.Sprintf("%s is outside the tolerance of %s", output, expected), true
+		},
+	}
+}
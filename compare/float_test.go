@@ -0,0 +1,92 @@
+package compare
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		token  string
+		value  float64
+		number bool
+	}{
+		{"1", 1, true},
+		{"-2.5", -2.5, true},
+		{"+.5", 0.5, true},
+		{"3.", 3, true},
+		{"1e3", 1000, true},
+		{"1.5E-2", 0.015, true},
+		{"-0", 0, true},
+		{"1e400", math.Inf(1), true},
+		{"-1e400", math.Inf(-1), true},
+		{"inf", math.Inf(1), true},
+		{"-Infinity", math.Inf(-1), true},
+		{"0x1p-2", 0, false},
+		{"1_000", 0, false},
+		{"--1", 0, false},
+		{".", 0, false},
+		{"e5", 0, false},
+		{"1e", 0, false},
+		{"1e+", 0, false},
+		{"1.2.3", 0, false},
+		{"YES", 0, false},
+	}
+
+	for _, tt := range tests {
+		v, ok := parseNumber(tt.token)
+		if ok != tt.number || ok && v != tt.value {
+			t.Errorf("parseNumber(%q) = %v, %v, want %v, %v", tt.token, v, ok, tt.value, tt.number)
+		}
+	}
+
+	for _, token := range []string{"nan", "NaN", "-nan", "+NAN"} {
+		if v, ok := parseNumber(token); !ok || !math.IsNaN(v) {
+			t.Errorf("parseNumber(%q) = %v, %v, want NaN", token, v, ok)
+		}
+	}
+}
+
+func TestToleranceEqual(t *testing.T) {
+	tests := []struct {
+		name             string
+		tolerance        Tolerance
+		output, expected float64
+		want             bool
+	}{
+		{"absolute within", Tolerance{Mode: Absolute, Absolute: 1e-6}, 0.300001, 0.3, true},
+		{"absolute outside", Tolerance{Mode: Absolute, Absolute: 1e-6}, 0.300002, 0.3, false},
+		{"absolute ignores magnitude", Tolerance{Mode: Absolute, Absolute: 1e-6}, 1e9 + 1, 1e9, false},
+		{"relative within", Tolerance{Mode: Relative, Relative: 1e-6}, 1e9 + 1, 1e9, true},
+		{"relative small value", Tolerance{Mode: Relative, Relative: 1e-6}, 1e-7, 0, false},
+		{"either absolute", Tolerance{Mode: AbsoluteOrRelative, Absolute: 1e-6, Relative: 1e-9}, 1e-7, 0, true},
+		{"either relative", Tolerance{Mode: AbsoluteOrRelative, Absolute: 1e-9, Relative: 1e-6}, 1e9 + 1, 1e9, true},
+		{"either neither", Tolerance{Mode: AbsoluteOrRelative, Absolute: 1e-9, Relative: 1e-9}, 1.001, 1, false},
+		{"hybrid", Tolerance{Absolute: 1e-6}, 1000.0005, 1000, true},
+		{"default tolerance", Tolerance{Mode: Absolute}, 1.0000005, 1, true},
+		{"negative zero", Tolerance{Mode: Absolute, Absolute: 1e-9}, math.Copysign(0, -1), 0, true},
+		{"nan", Tolerance{Mode: Absolute}, math.NaN(), math.NaN(), true},
+		{"nan against number", Tolerance{Mode: Absolute}, math.NaN(), 1, false},
+		{"infinity", Tolerance{Mode: Relative}, math.Inf(1), math.Inf(1), true},
+		{"opposite infinities", Tolerance{Mode: Relative}, math.Inf(-1), math.Inf(1), false},
+		{"infinity against large", Tolerance{Mode: Relative, Relative: 1}, 1e308, math.Inf(1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tolerance.Equal(tt.output, tt.expected); got != tt.want {
+				t.Errorf("Equal(%v, %v) = %v, want %v", tt.output, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFloatTokensAsText(t *testing.T) {
+	equal := floatRule(Tolerance{Mode: Absolute, Absolute: 1e-6}).equal
+	if equal("0x10", "16") {
+		t.Error("hex float compared as a number")
+	}
+	if !equal("0x10", "0x10") {
+		t.Error("identical text tokens differ")
+	}
+}
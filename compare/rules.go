@@ -0,0 +1,82 @@
+package compare
+
+import (
+	"fmt"
+	"strings"
+)
+
+// caseInsensitiveRule matches tokens that differ only in ASCII case.
+var caseInsensitiveRule = tokenRule{equal: strings.EqualFold, foldCase: true}
+
+// yesNoRule matches YES and NO answers in any case. Output tokens that are
+// neither are wrong even if the expected output has them.
+var yesNoRule = tokenRule{
+	equal: func(output, expected string) bool {
+		return isYesNo(output) && strings.EqualFold(output, expected)
+	},
+	foldCase: true,
+	longByte: func(int, byte) bool { return false },
+	explain: func(output, expected string) (Kind, string, bool) {
+		if isYesNo(output) {
+			return "", "", false
+		}
+		return Different, fmt.Sprintf("%q is not YES or NO", output), true
+	},
+}
+
+func isYesNo(token string) bool {
+	return strings.EqualFold(token, "yes") || strings.EqualFold(token, "no")
+}
+
+// integerRule matches integers of any size by value, so +7, 007 and 7 are
+// equal and -0 equals 0. Tokens too long to read at once must be digits
+// after an optional sign, and are compared as text.
+var integerRule = tokenRule{
+	equal: func(output, expected string) bool {
+		out, ok := normalizeInteger(output)
+		if !ok {
+			return false
+		}
+		exp, ok := normalizeInteger(expected)
+		return ok && out == exp
+	},
+	longByte: func(i int, b byte) bool {
+		return '0' <= b && b <= '9' || i == 0 && (b == '-' || b == '+')
+	},
+	explain: func(output, expected string) (Kind, string, bool) {
+		if _, ok := normalizeInteger(output); ok {
+			return "", "", false
+		}
+		return Different, fmt.Sprintf("%q is not an integer", output), true
+	},
+}
+
+// normalizeInteger strips the plus sign and leading zeros of a decimal
+// integer.
+func normalizeInteger(token string) (string, bool) {
+	negative := false
+	switch {
+	case strings.HasPrefix(token, "-"):
+		negative = true
+		token = token[1:]
+	case strings.HasPrefix(token, "+"):
+		token = token[1:]
+	}
+	if token == "" {
+		return "", false
+	}
+	for i := 0; i < len(token); i++ {
+		if token[i] < '0' || token[i] > '9' {
+			return "", false
+		}
+	}
+
+	token = strings.TrimLeft(token, "0")
+	if token == "" {
+		return "0", true
+	}
+	if negative {
+		token = "-" + token
+	}
+	return token, true
+}
@@ -0,0 +1,92 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinModes(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             Mode
+		output, expected string
+		match            bool
+	}{
+		{"case insensitive", CaseInsensitive, "Hello WORLD\n", "hello world\n", true},
+		{"case insensitive ignores line breaks", CaseInsensitive, "a\nb\n", "A B", true},
+		{"case insensitive long token", CaseInsensitive, strings.Repeat("Ab", 300), strings.Repeat("aB", 300), true},
+		{"case insensitive long token differs", CaseInsensitive, strings.Repeat("Ab", 300), strings.Repeat("aB", 299) + "aC", false},
+		{"case insensitive different", CaseInsensitive, "hello\n", "help\n", false},
+
+		{"yes no", YesNo, "yes\nNo\nYES\n", "YES\nNO\nyes\n", true},
+		{"yes no wrong answer", YesNo, "NO\n", "YES\n", false},
+		{"yes no other token", YesNo, "maybe\n", "maybe\n", false},
+		{"yes no long token", YesNo, strings.Repeat("yes", 100), strings.Repeat("yes", 100), false},
+
+		{"integers", Integers, "+7 007 -0 12345678901234567890123\n", "7 7 0 12345678901234567890123\n", true},
+		{"integers different", Integers, "8\n", "7\n", false},
+		{"integers sign", Integers, "-7\n", "7\n", false},
+		{"integers not a number", Integers, "7.0\n", "7\n", false},
+		{"integers sign only", Integers, "-\n", "-\n", false},
+		{"integers long", Integers, "-" + strings.Repeat("9", 300), "-" + strings.Repeat("9", 300), true},
+		{"integers long not a number", Integers, strings.Repeat("x", 300), strings.Repeat("x", 300), false},
+		{"integers long not a number at the end", Integers, strings.Repeat("9", 300) + "x", strings.Repeat("9", 300) + "x", false},
+
+		{"unordered lines", UnorderedLines, "b 1\na 2  \n\n", "a 2\nb 1\n", true},
+		{"unordered lines duplicates", UnorderedLines, "a\na\nb\n", "a\nb\nb\n", false},
+		{"unordered lines keep leading space", UnorderedLines, " a\n", "a\n", false},
+		{"unordered lines keep spacing within", UnorderedLines, "a  b\n", "a b\n", false},
+		{"unordered lines no final newline", UnorderedLines, "b\na", "a\nb\n", true},
+
+		{"unordered tokens", UnorderedTokens, "3 1\n2\n", "1 2 3\n", true},
+		{"unordered tokens count", UnorderedTokens, "1 2 2\n", "1 2\n", false},
+		{"unordered tokens missing", UnorderedTokens, "1\n", "1 1\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (m == nil) != tt.match {
+				t.Errorf("mismatch = %+v, want match %v", m, tt.match)
+			}
+		})
+	}
+}
+
+func TestBuiltinReasons(t *testing.T) {
+	tests := []struct {
+		mode             Mode
+		output, expected string
+		want             Mismatch
+	}{
+		{YesNo, "YES maybe\n", "YES NO\n", Mismatch{Line: 1, ExpectedLine: 1, Token: 2, Output: "YES maybe", Expected: "YES NO", Kind: Different}},
+		{Integers, "1 x\n", "1 2\n", Mismatch{Line: 1, ExpectedLine: 1, Token: 2, Output: "1 x", Expected: "1 2", Kind: Different}},
+		{UnorderedLines, "b\nc\na\n", "a\nb\n", Mismatch{Line: 2, Output: "c", Kind: ExtraOutput}},
+		{UnorderedLines, "b\nc\n", "a\nb\n", Mismatch{Line: 2, Output: "c", Kind: Different}},
+		{UnorderedLines, "b\n\n", "a\nb\n", Mismatch{Line: 2, ExpectedLine: 1, Expected: "a", Kind: MissingOutput}},
+		{UnorderedLines, "", "a\n", Mismatch{Line: 1, ExpectedLine: 1, Expected: "a", Kind: MissingOutput}},
+		{UnorderedTokens, "2\n", "1\n2\n", Mismatch{Line: 2, ExpectedLine: 1, Token: 2, Expected: "1", Kind: MissingOutput}},
+	}
+
+	for _, tt := range tests {
+		m, err := Readers(strings.NewReader(tt.output), strings.NewReader(tt.expected), Options{Mode: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil {
+			t.Errorf("Readers(%q, %q) matched", tt.output, tt.expected)
+			continue
+		}
+		got := *m
+		if got.Reason == "" {
+			t.Errorf("Readers(%q, %q) has no reason", tt.output, tt.expected)
+		}
+		got.Reason = ""
+		if got != tt.want {
+			t.Errorf("Readers(%q, %q) = %+v, want %+v", tt.output, tt.expected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package compare
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tokenPrefixLen is how much of a token is read before comparing. Longer
+// tokens are compared byte by byte as strings, if the rule allows them.
+const tokenPrefixLen = 256
+
+type boundary int
+
+const (
+	atToken boundary = iota
+	atLineBreak
+	atEOF
+)
+
+type tokenReader struct {
+	r          *bufio.Reader
+	lineBreaks bool // report line breaks between tokens
+	line       int
+	token      int    // tokens started so far
+	before     string // the last token matched on the current line
+}
+
+func newTokenReader(r io.ReaderAt, lineBreaks bool) *tokenReader {
+	return &tokenReader{r: newBufferedReader(r), lineBreaks: lineBreaks, line: 1}
+}
+
+// skip moves to the start of the next token. With lineBreaks set, one or
+// more line breaks between two tokens are reported once as atLineBreak
+// before the token itself; breaks before the first token or after the last
+// are ignored.
+func (t *tokenReader) skip() (boundary, error) {
+	sawBreak := false
+	for {
+		b, err := t.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return atEOF, nil
+		}
+		if err != nil {
+			return atEOF, err
+		}
+		if b == '\n' {
+			t.line++
+			t.before = ""
+			sawBreak = true
+			continue
+		}
+		if isSpace(b) {
+			continue
+		}
+
+		if err := t.r.UnreadByte(); err != nil {
+			return atEOF, err
+		}
+		if sawBreak && t.lineBreaks && t.token > 0 {
+			return atLineBreak, nil
+		}
+		t.token++
+		return atToken, nil
+	}
+}
+
+// tokenByte returns the next byte of the current token, or false once the
+// token has ended.
+func (t *tokenReader) tokenByte() (byte, bool, error) {
+	b, err := t.r.ReadByte()
+	if errors.Is(err, io.EOF) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if b == '\n' || isSpace(b) {
+		return 0, false, t.r.UnreadByte()
+	}
+	return b, true, nil
+}
+
+// prefix reads up to n bytes of the current token and reports whether that
+// was all of it.
+func (t *tokenReader) prefix(n int) (string, bool, error) {
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		b, ok, err := t.tokenByte()
+		if err != nil {
+			return "", false, err
+		}
+		if !ok {
+			return string(buf), true, nil
+		}
+		buf = append(buf, b)
+	}
+	next, err := t.r.Peek(1)
+	if errors.Is(err, io.EOF) {
+		return string(buf), true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(buf), next[0] == '\n' || isSpace(next[0]), nil
+}
+
+// tokenRule decides when two tokens match. The zero value requires them to
+// be identical.
+type tokenRule struct {
+	equal func(output, expected string) bool
+	// foldCase compares tokens too long for equal ignoring ASCII case.
+	foldCase bool
+	// longByte accepts byte b at index i of a token too long for equal; a
+	// token with a byte it refuses is wrong. Nil accepts any byte.
+	longByte func(i int, b byte) bool
+	// explain describes two complete tokens that differ; ok is false to keep
+	// the generic description.
+	explain func(output, expected string) (kind Kind, reason string, ok bool)
+}
+
+func (r tokenRule) matches(output, expected string) bool {
+	if r.equal == nil {
+		return output == expected
+	}
+	return r.equal(output, expected)
+}
+
+// acceptsLong reports whether s, starting at index i of a token too long
+// for equal, may be part of a matching token.
+func (r tokenRule) acceptsLong(i int, s string) bool {
+	if r.longByte == nil {
+		return true
+	}
+	for j := 0; j < len(s); j++ {
+		if !r.longByte(i+j, s[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// mismatch describes differing tokens, in the rule's words if it has any.
+func (r tokenRule) mismatch(output, expected *tokenReader, out, exp string) *Mismatch {
+	m := tokenMismatch(output, expected, out, exp)
+	if r.explain != nil {
+		if kind, reason, ok := r.explain(out, exp); ok {
+			m.Kind = kind
+			m.Reason = fmt.Sprintf("token %d: %s", output.token, reason)
+		}
+	}
+	return m
+}
+
+func (r tokenRule) sameByte(output, expected byte) bool {
+	if r.foldCase {
+		return lower(output) == lower(expected)
+	}
+	return output == expected
+}
+
+func (r tokenRule) samePrefix(output, expected string) bool {
+	if r.foldCase {
+		return strings.EqualFold(output, expected)
+	}
+	return output == expected
+}
+
+func lower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// compareTokens walks both token streams in step, matching tokens by rule.
+func compareTokens(output, expected *tokenReader, rule tokenRule) (*Mismatch, error) {
+	for {
+		outBoundary, err := output.skip()
+		if err != nil {
+			return nil, fmt.Errorf("reading output: %w", err)
+		}
+		expBoundary, err := expected.skip()
+		if err != nil {
+			return nil, fmt.Errorf("reading expected output: %w", err)
+		}
+
+		if outBoundary != expBoundary {
+			return boundaryMismatch(output, expected, outBoundary, expBoundary)
+		}
+		if outBoundary == atEOF {
+			return nil, nil
+		}
+		if outBoundary == atLineBreak {
+			continue
+		}
+
+		out, outDone, err := output.prefix(tokenPrefixLen)
+		if err != nil {
+			return nil, fmt.Errorf("reading output: %w", err)
+		}
+		exp, expDone, err := expected.prefix(tokenPrefixLen)
+		if err != nil {
+			return nil, fmt.Errorf("reading expected output: %w", err)
+		}
+
+		if outDone && expDone {
+			if !rule.matches(out, exp) {
+				return rule.mismatch(output, expected, out, exp), nil
+			}
+			output.before, expected.before = out, exp
+			continue
+		}
+
+		// At least one token is too long for rule.equal: compare as strings,
+		// if the rule allows such a token.
+		accepted := rule.acceptsLong(0, out)
+		if !outDone {
+			out += "..."
+		}
+		if !expDone {
+			exp += "..."
+		}
+		if !accepted {
+			return rule.mismatch(output, expected, out, exp), nil
+		}
+		if !rule.samePrefix(out, exp) || outDone != expDone {
+			return tokenMismatch(output, expected, out, exp), nil
+		}
+		for i := tokenPrefixLen; ; i++ {
+			o, outOk, err := output.tokenByte()
+			if err != nil {
+				return nil, fmt.Errorf("reading output: %w", err)
+			}
+			e, expOk, err := expected.tokenByte()
+			if err != nil {
+				return nil, fmt.Errorf("reading expected output: %w", err)
+			}
+			if outOk && rule.longByte != nil && !rule.longByte(i, o) {
+				return rule.mismatch(output, expected, out, exp), nil
+			}
+			if outOk != expOk || !rule.sameByte(o, e) {
+				return tokenMismatch(output, expected, out, exp), nil
+			}
+			if !outOk {
+				break
+			}
+		}
+		output.before, expected.before = "...", "..."
+	}
+}
+
+func tokenMismatch(output, expected *tokenReader, out, exp string) *Mismatch {
+	out, exp = truncate(out), truncate(exp)
+	return &Mismatch{
+		Line:         output.line,
+		ExpectedLine: expected.line,
+		Token:        output.token,
+		Output:       withContext(output.before, " ", out),
+		Expected:     withContext(expected.before, " ", exp),
+		Kind:         Different,
+		Reason:       fmt.Sprintf("token %d: expected %q, found %q", output.token, exp, out),
+	}
+}
+
+func boundaryMismatch(output, expected *tokenReader, outBoundary, expBoundary boundary) (*Mismatch, error) {
+	m := &Mismatch{Line: output.line, ExpectedLine: expected.line, Token: output.token}
+	outputBroke := outBoundary == atLineBreak
+
+	// Show the tokens that follow each boundary.
+	if outBoundary == atLineBreak {
+		outBoundary, _ = output.skip()
+	}
+	var out, exp string
+	if outBoundary == atToken {
+		out, _, _ = output.prefix(excerptLen)
+		m.Token = output.token
+	}
+	if expBoundary == atLineBreak {
+		expBoundary, _ = expected.skip()
+	}
+	if expBoundary == atToken {
+		exp, _, _ = expected.prefix(excerptLen)
+	}
+	m.Output = withContext(output.before, " ", out)
+	m.Expected = withContext(expected.before, " ", exp)
+
+	switch {
+	case outBoundary == atEOF:
+		m.Kind = MissingOutput
+		m.Reason = fmt.Sprintf("output ended early, expected %q", exp)
+	case expBoundary == atEOF:
+		m.Kind = ExtraOutput
+		m.Reason = fmt.Sprintf("unexpected extra output %q", out)
+	case outputBroke:
+		m.Kind = TokenCount
+		m.Reason = fmt.Sprintf("unexpected line break before %q", out)
+	default:
+		m.Kind = TokenCount
+		m.Reason = fmt.Sprintf("expected a line break before %q", exp)
+	}
+	return m, nil
+}
+
+func truncate(s string) string {
+	if len(s) > excerptLen {
+		return s[:excerptLen] + "..."
+	}
+	return s
+}
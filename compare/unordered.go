@@ -0,0 +1,130 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// item is a line or token of an unordered comparison.
+type item struct {
+	text  string
+	line  int
+	token int
+}
+
+// readLines returns the non-blank lines of r without trailing whitespace.
+func readLines(r io.ReaderAt) ([]item, error) {
+	br := newBufferedReader(r)
+	var items []item
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		end := len(text)
+		for end > 0 && (text[end-1] == '\n' || isSpace(text[end-1])) {
+			end--
+		}
+		if end > 0 {
+			items = append(items, item{text: text[:end], line: line})
+		}
+		if err != nil {
+			return items, nil
+		}
+	}
+}
+
+// readTokens returns the whitespace-separated tokens of r.
+func readTokens(r io.ReaderAt) ([]item, error) {
+	tokens := newTokenReader(r, false)
+	var items []item
+	for {
+		b, err := tokens.skip()
+		if err != nil {
+			return nil, err
+		}
+		if b == atEOF {
+			return items, nil
+		}
+		var buf []byte
+		for {
+			c, ok, err := tokens.tokenByte()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			buf = append(buf, c)
+		}
+		items = append(items, item{text: string(buf), line: tokens.line, token: tokens.token})
+	}
+}
+
+// compareUnordered compares output and expected as multisets. what names
+// the items in reasons. Both are held in memory, unlike the other modes.
+func compareUnordered(output, expected []item, what string) *Mismatch {
+	remaining := make(map[string]int, len(expected))
+	for _, e := range expected {
+		remaining[e.text]++
+	}
+
+	for _, o := range output {
+		if remaining[o.text] > 0 {
+			remaining[o.text]--
+			continue
+		}
+		m := &Mismatch{Line: o.line, Token: o.token, Output: truncate(o.text)}
+		if len(output) > len(expected) {
+			m.Kind = ExtraOutput
+			m.Reason = fmt.Sprintf("unexpected extra %s %q", what, m.Output)
+		} else {
+			m.Kind = Different
+			m.Reason = fmt.Sprintf("%s %q is not in the expected output, or appears too often", what, m.Output)
+		}
+		return m
+	}
+
+	// A missing item is reported just past the end of the output.
+	end := item{line: 1}
+	if len(output) > 0 {
+		end = output[len(output)-1]
+		end.line++
+	}
+	if end.token > 0 {
+		end.token++
+	}
+	for _, e := range expected {
+		if remaining[e.text] > 0 {
+			m := &Mismatch{Line: end.line, ExpectedLine: e.line, Token: end.token, Expected: truncate(e.text), Kind: MissingOutput}
+			m.Reason = fmt.Sprintf("missing %s %q", what, m.Expected)
+			return m
+		}
+	}
+	return nil
+}
+
+func compareUnorderedLines(output, expected io.ReaderAt) (*Mismatch, error) {
+	out, err := readLines(output)
+	if err != nil {
+		return nil, fmt.Errorf("reading output: %w", err)
+	}
+	exp, err := readLines(expected)
+	if err != nil {
+		return nil, fmt.Errorf("reading expected output: %w", err)
+	}
+	return compareUnordered(out, exp, "line"), nil
+}
+
+func compareUnorderedTokens(output, expected io.ReaderAt) (*Mismatch, error) {
+	out, err := readTokens(output)
+	if err != nil {
+		return nil, fmt.Errorf("reading output: %w", err)
+	}
+	exp, err := readTokens(expected)
+	if err != nil {
+		return nil, fmt.Errorf("reading expected output: %w", err)
+	}
+	return compareUnordered(out, exp, "token"), nil
+}
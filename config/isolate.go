@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultBoxRoot = "/var/local/lib/isolate"
+
+// isolateConfigPaths are where isolate installs its config file, newest
+// layout first.
+var isolateConfigPaths = []string{"/etc/isolate", "/usr/local/etc/isolate"}
+
+type isolateSettings struct {
+	path     string
+	boxRoot  string
+	numBoxes int
+}
+
+// readIsolateConfig parses box_root and num_boxes from isolate's config
+// file. With an empty path the standard locations are tried and a missing
+// file is not an error.
+func readIsolateConfig(path string) (*isolateSettings, error) {
+	candidates := isolateConfigPaths
+	if path != "" {
+		candidates = []string{path}
+	}
+
+	for _, candidate := range candidates {
+		file, err := os.Open(candidate)
+		if errors.Is(err, fs.ErrNotExist) && path == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading isolate config: %w", err)
+		}
+		defer file.Close()
+
+		settings := &isolateSettings{path: candidate}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "box_root":
+				settings.boxRoot = strings.TrimSpace(value)
+			case "num_boxes":
+				if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+					settings.numBoxes = n
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading isolate config %s: %w", candidate, err)
+		}
+		return settings, nil
+	}
+	return nil, nil
+}
+
+// resolveSandbox fills in the box root from isolate's config when it is not
+// set explicitly, and checks the box-id range against isolate's num_boxes.
+// The rlimit backend keeps its boxes under the temp directory by default.
+func (c *Config) resolveSandbox() error {
+	if c.Sandbox == "rlimit" {
+		if c.SandboxRoot == "" {
+			c.SandboxRoot = filepath.Join(os.TempDir(), "judge-deamon")
+		}
+		return nil
+	}
+
+	isolate, err := readIsolateConfig(c.IsolateConfig)
+	if err != nil {
+		return err
+	}
+
+	if c.SandboxRoot == "" {
+		c.SandboxRoot = defaultBoxRoot
+		if isolate != nil && isolate.boxRoot != "" {
+			c.SandboxRoot = isolate.boxRoot
+		}
+	}
+
+	if isolate != nil && isolate.numBoxes > 0 && c.BoxIdMax >= isolate.numBoxes {
+		return fmt.Errorf("box_id_max %d is outside isolate's num_boxes %d (%s)", c.BoxIdMax, isolate.numBoxes, isolate.path)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package config
+
+// Language describes how to build and run one language. Entries in the
+// config file are merged by Name over the built-in definitions: fields left
+// empty keep the built-in value, and unknown names add a new language.
+type Language struct {
+	Name       string   `yaml:"name"`
+	Aliases    []string `yaml:"aliases"`
+	SourceFile string   `yaml:"source_file"`
+	// Compile runs on the host with the box directory as working directory.
+	// Empty for interpreted languages.
+	Compile []string `yaml:"compile"`
+	// Binary must exist after Compile succeeds.
+	Binary string   `yaml:"binary"`
+	Run    []string `yaml:"run"`
+	// Processes is isolate's --processes limit; 0 keeps isolate's default.
+	Processes int `yaml:"processes"`
+	// WallTimeMultiplier overrides the global wall_time_multiplier, e.g. for
+	// runtimes with a slow start; 0 keeps the global value.
+	WallTimeMultiplier float32 `yaml:"wall_time_multiplier"`
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	env "github.com/joho/godotenv"
+	"go.yaml.in/yaml/v2"
+)
+
+const defaultConfigFile = "config.yaml"
+
+// setting maps one Config field to its environment variable and flag. The
+// config file key is the field's yaml tag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(envKey, flagName, usage string, field func(*Config) *string) setting {
+	return setting{envKey, flagName, usage, func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intSetting(envKey, flagName, usage string, field func(*Config) *int) setting {
+	return setting{envKey, flagName, usage, func(c *Config, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		*field(c) = v
+		return nil
+	}}
+}
+
+func floatSetting(envKey, flagName, usage string, field func(*Config) *float32) setting {
+	return setting{envKey, flagName, usage, func(c *Config, value string) error {
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		*field(c) = float32(v)
+		return nil
+	}}
+}
+
+func durationSetting(envKey, flagName, usage string, field func(*Config) *time.Duration) setting {
+	return setting{envKey, flagName, usage, func(c *Config, value string) error {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 30s, got %q", value)
+		}
+		*field(c) = v
+		return nil
+	}}
+}
+
+var settings = []setting{
+	intSetting("WORKER_COUNT", "worker-count", "number of parallel isolate sandboxes", func(c *Config) *int { return &c.WorkerCount }),
+	stringSetting("QUEUE_NAME", "queue-name", "name of the submission queue", func(c *Config) *string { return &c.QueueName }),
+	stringSetting("RABBITMQ_URL", "rabbitmq-url", "RabbitMQ connection URL", func(c *Config) *string { return &c.RabbitMQURL }),
+	stringSetting("HTTP_PORT", "http-port", "HTTP listen port", func(c *Config) *string { return &c.HttpPort }),
+	stringSetting("ENGINE_KEY", "engine-key", "shared secret with the server", func(c *Config) *string { return &c.EngineKey }),
+	stringSetting("SERVER_ENDPOINT", "server-endpoint", "base URL of the server", func(c *Config) *string { return &c.ServerEndpoint }),
+	stringSetting("BROKER", "broker", "message broker: rabbitmq or memory", func(c *Config) *string { return &c.Broker }),
+	stringSetting("BROKER_FILE", "broker-file", "state file of the memory broker", func(c *Config) *string { return &c.BrokerFile }),
+
+	floatSetting("MAX_TIME_LIMIT", "max-time-limit", "largest accepted time limit in seconds", func(c *Config) *float32 { return &c.MaxTimeLimit }),
+	floatSetting("MAX_MEMORY_LIMIT", "max-memory-limit", "largest accepted memory limit in megabytes", func(c *Config) *float32 { return &c.MaxMemoryLimit }),
+	floatSetting("MAX_OUTPUT_LIMIT", "max-output-limit", "largest accepted output limit in megabytes", func(c *Config) *float32 { return &c.MaxOutputLimit }),
+	intSetting("MAX_SOURCE_SIZE", "max-source-size", "largest accepted source code in bytes", func(c *Config) *int { return &c.MaxSourceSize }),
+	intSetting("MAX_TESTCASE_SIZE", "max-testcase-size", "largest accepted testcase input or output in bytes", func(c *Config) *int { return &c.MaxTestcaseSize }),
+
+	stringSetting("SANDBOX", "sandbox", "sandbox backend: isolate or rlimit", func(c *Config) *string { return &c.Sandbox }),
+	stringSetting("ISOLATE_CONFIG", "isolate-config", "isolate's config file", func(c *Config) *string { return &c.IsolateConfig }),
+	stringSetting("SANDBOX_ROOT", "sandbox-root", "isolate box root directory (default: isolate's box_root)", func(c *Config) *string { return &c.SandboxRoot }),
+	intSetting("BOX_ID_MIN", "box-id-min", "first isolate box id this engine may use", func(c *Config) *int { return &c.BoxIdMin }),
+	intSetting("BOX_ID_MAX", "box-id-max", "last isolate box id this engine may use", func(c *Config) *int { return &c.BoxIdMax }),
+	stringSetting("BOX_LOCK_DIR", "box-lock-dir", "directory for per-box lock files", func(c *Config) *string { return &c.BoxLockDir }),
+	intSetting("FSIZE_KB", "fsize-kb", "largest file a run may write, in kilobytes", func(c *Config) *int { return &c.FsizeKB }),
+	floatSetting("WALL_TIME_MULTIPLIER", "wall-time-multiplier", "wall-time limit as a multiple of the time limit", func(c *Config) *float32 { return &c.WallTimeMultiplier }),
+	durationSetting("COMPILE_TIMEOUT", "compile-timeout", "maximum compilation time", func(c *Config) *time.Duration { return &c.CompileTimeout }),
+	intSetting("COMPILE_WORKERS", "compile-workers", "number of submissions compiling at once", func(c *Config) *int { return &c.CompileWorkers }),
+	intSetting("PARALLEL_TESTS", "parallel-tests", "most boxes one submission's tests run on at once", func(c *Config) *int { return &c.ParallelTests }),
+	intSetting("RESET_ATTEMPTS", "reset-attempts", "failed resets of a box before it is quarantined", func(c *Config) *int { return &c.ResetAttempts }),
+	durationSetting("RESET_RETRY_DELAY", "reset-retry-delay", "delay before retrying a failed box reset, doubled on every attempt", func(c *Config) *time.Duration { return &c.ResetRetryDelay }),
+	durationSetting("QUARANTINE_RETRY", "quarantine-retry", "interval between resets of a quarantined box", func(c *Config) *time.Duration { return &c.QuarantineRetry }),
+	stringSetting("CHECKER_DIR", "checker-dir", "directory of custom checker executables", func(c *Config) *string { return &c.CheckerDir }),
+	durationSetting("CHECKER_TIMEOUT", "checker-timeout", "maximum run time of a custom checker per test", func(c *Config) *time.Duration { return &c.CheckerTimeout }),
+
+	stringSetting("TESTCASE_ENDPOINT", "testcase-endpoint", "base URL of the server's testcase API", func(c *Config) *string { return &c.TestcaseEndpoint }),
+	stringSetting("TESTCASE_CACHE_DIR", "testcase-cache-dir", "directory of the testcase cache", func(c *Config) *string { return &c.TestcaseCacheDir }),
+	intSetting("TESTCASE_CACHE_MB", "testcase-cache-mb", "disk budget of the testcase cache in megabytes", func(c *Config) *int { return &c.TestcaseCacheMB }),
+	durationSetting("TESTCASE_FETCH_TIMEOUT", "testcase-fetch-timeout", "timeout for fetching one testcase file", func(c *Config) *time.Duration { return &c.TestcaseFetchTimeout }),
+
+	stringSetting("ARTIFACT_CACHE_DIR", "artifact-cache-dir", "directory of the compiled artifact cache", func(c *Config) *string { return &c.ArtifactCacheDir }),
+	intSetting("ARTIFACT_CACHE_MB", "artifact-cache-mb", "disk budget of the compiled artifact cache in megabytes, 0 to disable", func(c *Config) *int { return &c.ArtifactCacheMB }),
+
+	intSetting("MAX_RETRIES", "max-retries", "retries of a failed job before it is parked", func(c *Config) *int { return &c.MaxRetries }),
+	durationSetting("RETRY_BASE_DELAY", "retry-base-delay", "delay before the first retry", func(c *Config) *time.Duration { return &c.RetryBaseDelay }),
+	durationSetting("RETRY_MAX_DELAY", "retry-max-delay", "upper bound of the retry delay", func(c *Config) *time.Duration { return &c.RetryMaxDelay }),
+	durationSetting("WORKER_WAIT_TIMEOUT", "worker-wait-timeout", "how long a queued job waits for a free worker", func(c *Config) *time.Duration { return &c.WorkerWaitTimeout }),
+	durationSetting("RUN_WAIT_TIMEOUT", "run-wait-timeout", "how long a /run request waits for a free worker", func(c *Config) *time.Duration { return &c.RunWaitTimeout }),
+
+	durationSetting("HTTP_READ_TIMEOUT", "http-read-timeout", "HTTP server read timeout", func(c *Config) *time.Duration { return &c.HTTPReadTimeout }),
+	durationSetting("HTTP_WRITE_TIMEOUT", "http-write-timeout", "HTTP server write timeout", func(c *Config) *time.Duration { return &c.HTTPWriteTimeout }),
+	durationSetting("HTTP_IDLE_TIMEOUT", "http-idle-timeout", "HTTP server idle timeout", func(c *Config) *time.Duration { return &c.HTTPIdleTimeout }),
+	durationSetting("SHUTDOWN_TIMEOUT", "shutdown-timeout", "graceful shutdown timeout", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	durationSetting("VERDICT_TIMEOUT", "verdict-timeout", "timeout for reporting a verdict to the server", func(c *Config) *time.Duration { return &c.VerdictTimeout }),
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and args, in that order of priority, and validates it. All
+// problems are returned together.
+func Load(args []string) (*Config, error) {
+	if err := env.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("loading .env: %w", err)
+	}
+
+	flags := flag.NewFlagSet("judge-deamon", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (default "+defaultConfigFile+" if present)")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.flag
+		flags.Func(name, s.usage+" (env "+s.env+")", func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := defaultConfig()
+	var errs []error
+
+	if err := loadFile(&config, *configFile); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		if err := s.set(&config, strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flagValues[s.flag]
+		if !ok {
+			continue
+		}
+		if err := s.set(&config, value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+		}
+	}
+
+	if err := config.resolveSandbox(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := config.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &config, nil
+}
+
+// loadFile overlays a YAML file on config. An explicitly named file must
+// exist; the default one is optional.
+func loadFile(config *Config, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	log.Printf("Loaded config file %s", path)
+	return nil
+}
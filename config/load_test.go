@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inTempDir runs the test in an empty directory, so no config.yaml or .env
+// is picked up, with none of the settings' environment variables set.
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, key := range append([]string{"CONFIG_FILE"}, settingEnvKeys()...) {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	return dir
+}
+
+func settingEnvKeys() []string {
+	keys := make([]string, len(settings))
+	for i, s := range settings {
+		keys[i] = s.env
+	}
+	return keys
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, dir, "config.yaml", `
+engine_key: secret
+server_endpoint: http://server
+sandbox: rlimit
+worker_count: 2
+compile_workers: 3
+queue_name: from_yaml
+http_port: "9000"
+`)
+	writeFile(t, dir, ".env", "QUEUE_NAME=from_dotenv\nHTTP_PORT=9100\n")
+	t.Setenv("HTTP_PORT", "9200")
+	t.Setenv("WORKER_COUNT", "3")
+
+	c, err := Load([]string{"-worker-count", "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.MaxRetries != 5 {
+		t.Errorf("max_retries = %d, want the default 5", c.MaxRetries)
+	}
+	if c.CompileWorkers != 3 {
+		t.Errorf("compile_workers = %d, want 3 from the config file", c.CompileWorkers)
+	}
+	if c.QueueName != "from_dotenv" {
+		t.Errorf("queue_name = %q, want .env over the config file", c.QueueName)
+	}
+	if c.HttpPort != "9200" {
+		t.Errorf("http_port = %q, want the environment over .env", c.HttpPort)
+	}
+	if c.WorkerCount != 4 {
+		t.Errorf("worker_count = %d, want the flag over the environment", c.WorkerCount)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, dir, "config.yaml", "engine_key: secret\nserver_endpoint: http://server\nsandbox: rlimit\nworker_cuont: 2\n")
+
+	_, err := Load(nil)
+	if err == nil || !strings.Contains(err.Error(), "worker_cuont") {
+		t.Errorf("err = %v, want the unknown key reported", err)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, dir, "config.yaml", "sandbox: rlimit\nworker_count: 0\n")
+
+	_, err := Load([]string{"-max-retries", "many"})
+	if err == nil {
+		t.Fatal("loaded an invalid configuration")
+	}
+	for _, want := range []string{
+		`-max-retries: expected an integer, got "many"`,
+		"worker_count must be at least 1, got 0",
+		"engine_key is required",
+		"server_endpoint is required",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, missing %q", err, want)
+		}
+	}
+}
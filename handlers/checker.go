@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+// builtinCheckers maps checker_type values to their comparison modes,
+// besides "diff" (the default) and "float".
+var builtinCheckers = map[string]compare.Mode{
+	"case_insensitive": compare.CaseInsensitive,
+	"yesno":            compare.YesNo,
+	"integers":         compare.Integers,
+	"unordered_lines":  compare.UnorderedLines,
+	"unordered_tokens": compare.UnorderedTokens,
+}
+
+// KnownChecker reports whether checkerType names a checker.
+func KnownChecker(checkerType string) bool {
+	switch checkerType {
+	case "", "diff", "float", "custom":
+		return true
+	}
+	_, ok := builtinCheckers[checkerType]
+	return ok
+}
+
+// CheckResult is what a checker says about one test besides the verdict.
+type CheckResult struct {
+	Score   float64 // in [0, 1]
+	Message string
+	// Mismatch is the first difference found by a built-in checker.
+	Mismatch *compare.Mismatch
+}
+
+// Check judges one run with the submission's checker, setting finalResult.
+func (h *Handler) Check(ctx context.Context, files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string, submission *structs.Submission, tolerance compare.Tolerance) CheckResult {
+	var mismatch *compare.Mismatch
+	if mode, ok := builtinCheckers[submission.CheckerType]; ok {
+		mismatch = h.compareMode(files, meta, maxTime, maxRSS, finalResult, compare.Options{Mode: mode})
+	} else {
+		switch submission.CheckerType {
+		case "custom":
+			return h.CustomCheck(ctx, files, meta, maxTime, maxRSS, finalResult, submission.CheckerName)
+		case "float":
+			mismatch = h.CompareFloat(files, meta, maxTime, maxRSS, finalResult, tolerance, submission.CheckerPresentationError)
+		default:
+			mismatch = h.Compare(files, meta, maxTime, maxRSS, finalResult, submission.CheckerStrictSpace, submission.CheckerPresentationError)
+		}
+	}
+
+	result := CheckResult{Mismatch: mismatch}
+	if *finalResult == "ac" {
+		result.Score = 1
+	}
+	// Built-in messages quote the expected output.
+	if mismatch != nil && submission.CheckerDiagnostics {
+		result.Message = mismatch.String()
+	}
+	return result
+}
+
+func (h *Handler) compareMode(files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string, opts compare.Options) *compare.Mismatch {
+	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(files, meta, maxTime, maxRSS, finalResult)
+	if shouldReturn {
+		return nil
+	}
+	mismatch, err := compareOutput(outputPath, expectedOutputPath, opts)
+	if err != nil {
+		log.Printf("Error comparing output: %v", err)
+		*finalResult = "ie"
+		return nil
+	}
+	if mismatch != nil {
+		*finalResult = "wa"
+		return mismatch
+	}
+	*finalResult = "ac"
+	return nil
+}
+
+// compareOutput compares the output the program left in its box against the
+// expected output. An output that is not a regular file, say a symlink to a
+// host file, is a wrong answer and is never read.
+func compareOutput(outputPath, expectedOutputPath string, opts compare.Options) (*compare.Mismatch, error) {
+	output, err := sandbox.OpenOutput(outputPath)
+	if errors.Is(err, sandbox.ErrNotRegular) {
+		return &compare.Mismatch{Line: 1, ExpectedLine: 1, Kind: compare.Different, Reason: "the output is not a regular file"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	expected, err := os.Open(expectedOutputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer expected.Close()
+
+	return compare.Readers(output, expected, opts)
+}
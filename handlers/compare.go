@@ -1,23 +1,36 @@
 package handlers
 
 import (
-	"os/exec"
+	"log"
+
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/sandbox"
 )
 
-func (h *Handler) Compare(boxPath string, maxTime *float32, maxRSS *float32, finalResult *string, strictSpace bool) {
-	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(boxPath, maxTime, maxRSS, finalResult)
+// Compare sets finalResult and returns the first mismatch when the output
+// is wrong.
+func (h *Handler) Compare(files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string, strictSpace bool, presentationError bool) *compare.Mismatch {
+	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(files, meta, maxTime, maxRSS, finalResult)
 	if shouldReturn {
-		return
+		return nil
 	}
-	var diffCmd *exec.Cmd
+	mode := compare.Lines
 	if strictSpace {
-		diffCmd = exec.Command("diff", outputPath, expectedOutputPath)
-	} else {
-		diffCmd = exec.Command("diff", "-Z", "-B", outputPath, expectedOutputPath)
+		mode = compare.Exact
+	}
+	mismatch, err := compareOutput(outputPath, expectedOutputPath, compare.Options{Mode: mode})
+	if err != nil {
+		log.Printf("Error comparing output: %v", err)
+		*finalResult = "ie"
+		return nil
 	}
-	if _, err := diffCmd.CombinedOutput(); err != nil {
+	if mismatch != nil {
 		*finalResult = "wa"
+		if presentationError {
+			checkPresentation(outputPath, expectedOutputPath, compare.Options{Mode: compare.Tokens}, finalResult)
+		}
 	} else {
 		*finalResult = "ac"
 	}
+	return mismatch
 }
@@ -1,121 +1,95 @@
 package handlers
 
 import (
-	"bufio"
+	"fmt"
 	"log"
 	"math"
-	"os"
 	"strconv"
-	"strings"
-)
 
-func (h *Handler) CompareFloat(boxPath string, maxTime *float32, maxRSS *float32, finalResult *string, strictSpace bool, precision *string) {
-	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(boxPath, maxTime, maxRSS, finalResult)
-	if shouldReturn {
-		return
-	}
-
-	// Compare floating point outputs with precision tolerance
-	outputFile, err := os.Open(outputPath)
-	if err != nil {
-		log.Printf("Error opening output file: %v", err)
-		*finalResult = "ie"
-		return
-	}
-	defer outputFile.Close()
-
-	expectedFile, err := os.Open(expectedOutputPath)
-	if err != nil {
-		log.Printf("Error opening expected output file: %v", err)
-		*finalResult = "ie"
-		return
-	}
-	defer expectedFile.Close()
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
 
-	outputScanner := bufio.NewScanner(outputFile)
-	expectedScanner := bufio.NewScanner(expectedFile)
+// FloatModes maps checker_float_mode values to comparison modes. An empty
+// mode keeps the original hybrid rule.
+var FloatModes = map[string]compare.FloatMode{
+	"":                     compare.Hybrid,
+	"legacy":               compare.Hybrid,
+	"absolute":             compare.Absolute,
+	"relative":             compare.Relative,
+	"absolute_or_relative": compare.AbsoluteOrRelative,
+}
 
-	epsilon, err := strconv.ParseFloat(*precision, 64)
-	if err != nil || epsilon <= 0 {
-		epsilon = 1e-6 // default precision
+// FloatTolerance builds the float checker's tolerance from a submission.
+// checker_absolute_error and checker_relative_error take precedence over
+// checker_precision, which sets both.
+func FloatTolerance(submission *structs.Submission) (compare.Tolerance, error) {
+	mode, ok := FloatModes[submission.CheckerFloatMode]
+	if !ok {
+		return compare.Tolerance{}, fmt.Errorf("unknown float mode %q", submission.CheckerFloatMode)
 	}
+	tolerance := compare.Tolerance{Mode: mode}
 
-	for {
-		hasOutput := outputScanner.Scan()
-		hasExpected := expectedScanner.Scan()
-
-		if !hasOutput && !hasExpected {
-			// Both files ended at the same time - success
-			break
-		}
-
-		if hasOutput != hasExpected {
-			// Files have different number of lines
-			*finalResult = "wa"
-			return
+	if submission.CheckerPrecision != nil {
+		precision, ok := ParsePrecision(*submission.CheckerPrecision)
+		if !ok {
+			return compare.Tolerance{}, fmt.Errorf("invalid precision %q", *submission.CheckerPrecision)
 		}
-
-		outputLine := strings.TrimSpace(outputScanner.Text())
-		expectedLine := strings.TrimSpace(expectedScanner.Text())
-
-		if !strictSpace {
-			outputLine = strings.Join(strings.Fields(outputLine), " ")
-			expectedLine = strings.Join(strings.Fields(expectedLine), " ")
+		tolerance.Absolute = precision
+		tolerance.Relative = precision
+	}
+	if e := submission.CheckerAbsoluteError; e != nil {
+		if !ValidTolerance(*e) {
+			return compare.Tolerance{}, fmt.Errorf("invalid absolute error %v", *e)
 		}
-
-		// Split lines into tokens
-		outputTokens := strings.Fields(outputLine)
-		expectedTokens := strings.Fields(expectedLine)
-
-		if len(outputTokens) != len(expectedTokens) {
-			*finalResult = "wa"
-			return
+		tolerance.Absolute = *e
+	}
+	if e := submission.CheckerRelativeError; e != nil {
+		if !ValidTolerance(*e) {
+			return compare.Tolerance{}, fmt.Errorf("invalid relative error %v", *e)
 		}
+		tolerance.Relative = *e
+	}
+	return tolerance, nil
+}
 
-		// Compare each token
-		for i := 0; i < len(outputTokens); i++ {
-			outputVal, outputErr := strconv.ParseFloat(outputTokens[i], 64)
-			expectedVal, expectedErr := strconv.ParseFloat(expectedTokens[i], 64)
-
-			if outputErr != nil && expectedErr != nil {
-				// Both are not numbers, compare as strings
-				if outputTokens[i] != expectedTokens[i] {
-					*finalResult = "wa"
-					return
-				}
-			} else if outputErr != nil || expectedErr != nil {
-				// One is a number, the other is not
-				*finalResult = "wa"
-				return
-			} else {
-				// Both are numbers, compare with epsilon
+// ParsePrecision parses checker_precision and reports whether it is a valid
+// tolerance.
+func ParsePrecision(s string) (float64, bool) {
+	precision, err := strconv.ParseFloat(s, 64)
+	return precision, err == nil && ValidTolerance(precision)
+}
 
-				// Use relative error if values are large, absolute error otherwise
-				diff := math.Abs(outputVal - expectedVal)
-				maxVal := math.Max(math.Abs(outputVal), math.Abs(expectedVal))
+// ValidTolerance reports whether v can be used as an absolute or relative
+// error: positive and finite.
+func ValidTolerance(v float64) bool {
+	return v > 0 && !math.IsInf(v, 0) && !math.IsNaN(v)
+}
 
-				// Compute tolerance
-				tolerance := epsilon * (1 + maxVal)
-				// If difference exceeds tolerance -> WA
-				if diff > tolerance {
-					*finalResult = "wa"
-					return
-				}
-			}
-		}
+// CompareFloat sets finalResult and returns the first mismatch when the
+// output is wrong.
+func (h *Handler) CompareFloat(files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string, tolerance compare.Tolerance, presentationError bool) *compare.Mismatch {
+	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(files, meta, maxTime, maxRSS, finalResult)
+	if shouldReturn {
+		return nil
 	}
 
-	if err := outputScanner.Err(); err != nil {
-		log.Printf("Error reading output file: %v", err)
+	// Tokens are compared line by line, numbers within the tolerance
+	mismatch, err := compareOutput(outputPath, expectedOutputPath, compare.Options{Mode: compare.Float, Tolerance: tolerance})
+	if err != nil {
+		log.Printf("Error comparing output: %v", err)
 		*finalResult = "ie"
-		return
+		return nil
 	}
-
-	if err := expectedScanner.Err(); err != nil {
-		log.Printf("Error reading expected output file: %v", err)
-		*finalResult = "ie"
-		return
+	if mismatch != nil {
+		*finalResult = "wa"
+		if presentationError {
+			checkPresentation(outputPath, expectedOutputPath, compare.Options{Mode: compare.FloatTokens, Tolerance: tolerance}, finalResult)
+		}
+		return mismatch
 	}
 
 	*finalResult = "ac"
+	return nil
 }
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name             string
+		output, expected string
+		strictSpace      bool
+		want             string
+	}{
+		{"identical", "1 2\n3\n", "1 2\n3\n", true, "ac"},
+		{"different", "1 2\n4\n", "1 2\n3\n", false, "wa"},
+		{"trailing space lenient", "1 2  \n3\n", "1 2\n3\n", false, "ac"},
+		{"trailing space strict", "1 2  \n3\n", "1 2\n3\n", true, "wa"},
+		{"blank lines lenient", "1 2\n\n3\n", "1 2\n3\n", false, "ac"},
+		{"empty output", "", "1\n", false, "wa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := writeBox(t, tt.output, tt.expected)
+			var maxTime, maxRSS float32
+			result := "ac"
+			newTestHandler().Compare(files, &sandbox.Result{}, &maxTime, &maxRSS, &result, tt.strictSpace, false)
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareFloat(t *testing.T) {
+	precision := func(s string) *string { return &s }
+	tests := []struct {
+		name             string
+		output, expected string
+		precision        *string
+		want             string
+	}{
+		{"exact", "3.14159\n", "3.14159\n", nil, "ac"},
+		{"within default precision", "0.3333333\n", "0.3333334\n", nil, "ac"},
+		{"outside precision", "0.33\n", "0.34\n", precision("1e-3"), "wa"},
+		{"within custom precision", "0.333\n", "0.334\n", precision("1e-2"), "ac"},
+		{"words compared exactly", "YES 1.0\n", "YES 1.0000001\n", nil, "ac"},
+		{"word mismatch", "NO 1.0\n", "YES 1.0\n", nil, "wa"},
+		{"number against word", "1.0\n", "abc\n", nil, "wa"},
+		{"missing line", "1.0\n", "1.0\n2.0\n", nil, "wa"},
+		{"token count", "1.0 2.0\n", "1.0\n", nil, "wa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := writeBox(t, tt.output, tt.expected)
+			var maxTime, maxRSS float32
+			result := "ac"
+			tolerance, err := FloatTolerance(&structs.Submission{CheckerPrecision: tt.precision})
+			if err != nil {
+				t.Fatal(err)
+			}
+			newTestHandler().CompareFloat(files, &sandbox.Result{}, &maxTime, &maxRSS, &result, tolerance, false)
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareSkipsOutputAfterFailedRun(t *testing.T) {
+	files := writeBox(t, "42\n", "42\n")
+	var maxTime, maxRSS float32
+	result := "ac"
+	newTestHandler().Compare(files, &sandbox.Result{Status: "RE", ExitCode: 1}, &maxTime, &maxRSS, &result, false, false)
+	if result != "re" {
+		t.Errorf("result = %q, want re", result)
+	}
+}
+
+func TestCompareRejectsSymlinkedOutput(t *testing.T) {
+	files := writeBox(t, "", "secret\n")
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(files.Output); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, files.Output); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, checker := range []string{"diff", "float", "yesno"} {
+		var maxTime, maxRSS float32
+		result := "ac"
+		submission := &structs.Submission{CheckerType: checker, CheckerDiagnostics: true}
+		check := newTestHandler().Check(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, submission, compare.Tolerance{})
+		if result != "wa" || strings.Contains(check.Message, "secret") {
+			t.Errorf("%s: got %s %q, want wa without the linked file", checker, result, check.Message)
+		}
+	}
+}
+
+func TestPresentationError(t *testing.T) {
+	tests := []struct {
+		name             string
+		output, expected string
+		strictSpace      bool
+		float            bool
+		want             string
+	}{
+		{"accepted stays accepted", "1 2\n", "1 2\n", true, false, "ac"},
+		{"line breaks differ", "1\n2\n", "1 2\n", false, false, "pe"},
+		{"trailing space under strict", "1 2 \n", "1 2\n", true, false, "pe"},
+		{"different tokens", "1\n3\n", "1 2\n", false, false, "wa"},
+		{"missing token", "1\n", "1 2\n", false, false, "wa"},
+		{"float line breaks differ", "0.5\n0.25\n", "0.5 0.25\n", false, true, "pe"},
+		{"float within precision", "0.5000001\n0.25\n", "0.5 0.25\n", false, true, "pe"},
+		{"float wrong value", "0.6\n0.25\n", "0.5 0.25\n", false, true, "wa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := writeBox(t, tt.output, tt.expected)
+			var maxTime, maxRSS float32
+			result := "ac"
+			if tt.float {
+				newTestHandler().CompareFloat(files, &sandbox.Result{}, &maxTime, &maxRSS, &result, compare.Tolerance{}, true)
+			} else {
+				newTestHandler().Compare(files, &sandbox.Result{}, &maxTime, &maxRSS, &result, tt.strictSpace, true)
+			}
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareFloatLongLine(t *testing.T) {
+	// A single line longer than bufio.Scanner's default limit.
+	line := strings.Repeat("0.5 ", 100000) + "\n"
+	files := writeBox(t, line, line)
+	var maxTime, maxRSS float32
+	result := "ac"
+	newTestHandler().CompareFloat(files, &sandbox.Result{}, &maxTime, &maxRSS, &result, compare.Tolerance{}, false)
+	if result != "ac" {
+		t.Errorf("result = %q, want ac", result)
+	}
+}
+
+func TestFloatTolerance(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name       string
+		submission structs.Submission
+		want       compare.Tolerance
+		wantErr    bool
+	}{
+		{"default", structs.Submission{}, compare.Tolerance{}, false},
+		{"precision sets both", structs.Submission{CheckerPrecision: str("1e-4")}, compare.Tolerance{Absolute: 1e-4, Relative: 1e-4}, false},
+		{"explicit errors win", structs.Submission{CheckerFloatMode: "absolute_or_relative", CheckerPrecision: str("1e-4"), CheckerRelativeError: num(1e-9)},
+			compare.Tolerance{Mode: compare.AbsoluteOrRelative, Absolute: 1e-4, Relative: 1e-9}, false},
+		{"relative", structs.Submission{CheckerFloatMode: "relative", CheckerRelativeError: num(1e-6)}, compare.Tolerance{Mode: compare.Relative, Relative: 1e-6}, false},
+		{"invalid precision", structs.Submission{CheckerPrecision: str("abc")}, compare.Tolerance{}, true},
+		{"zero precision", structs.Submission{CheckerPrecision: str("0")}, compare.Tolerance{}, true},
+		{"negative error", structs.Submission{CheckerAbsoluteError: num(-1)}, compare.Tolerance{}, true},
+		{"unknown mode", structs.Submission{CheckerFloatMode: "ulp"}, compare.Tolerance{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FloatTolerance(&tt.submission)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("tolerance = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		checker          string
+		output, expected string
+		want             string
+	}{
+		{"", "1 2\n", "1 2\n", "ac"},
+		{"diff", "2 1\n", "1 2\n", "wa"},
+		{"float", "1.0000001\n", "1\n", "ac"},
+		{"case_insensitive", "Hello\n", "hello\n", "ac"},
+		{"yesno", "yes\n", "NO\n", "wa"},
+		{"integers", "007\n", "7\n", "ac"},
+		{"unordered_lines", "2\n1\n", "1\n2\n", "ac"},
+		{"unordered_tokens", "2 1 1\n", "1 2\n", "wa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.checker, func(t *testing.T) {
+			files := writeBox(t, tt.output, tt.expected)
+			var maxTime, maxRSS float32
+			result := "ac"
+			submission := &structs.Submission{CheckerType: tt.checker}
+			check := newTestHandler().Check(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, submission, compare.Tolerance{})
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+			if (check.Mismatch != nil) != (tt.want == "wa") {
+				t.Errorf("mismatch = %+v for %s", check.Mismatch, result)
+			}
+			if wantScore := map[bool]float64{true: 1}[tt.want == "ac"]; check.Score != wantScore {
+				t.Errorf("score = %v, want %v", check.Score, wantScore)
+			}
+			if check.Message != "" {
+				t.Errorf("message %q reported without checker_diagnostics", check.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+// Exit codes of testlib checkers.
+const (
+	checkerOK                = 0
+	checkerWrongAnswer       = 1
+	checkerPresentationError = 2
+	checkerFail              = 3
+	checkerDirt              = 4
+	checkerPoints            = 7
+)
+
+const (
+	// maxCheckerMessage bounds the message kept from a checker, and
+	// maxCheckerStderr what is read of its stderr to find it.
+	maxCheckerMessage = 256
+	maxCheckerStderr  = 4 << 10
+	// checkerWaitDelay is how long a checker that has exited may leave a
+	// child holding its stderr open.
+	checkerWaitDelay = time.Second
+)
+
+// CheckerPath returns the executable of the custom checker name in dir.
+func CheckerPath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("custom checkers are disabled, checker_dir is not set")
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid checker name %q", name)
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+		return "", fmt.Errorf("checker %s is not an executable file", path)
+	}
+	return path, nil
+}
+
+// CustomCheck runs a jury checker from checker_dir on the host as
+// "<checker> input output expected". It follows testlib: the exit code is
+// the verdict and the first line of stderr the message, which for points
+// (exit code 7) starts with the score in [0, 1], optionally after the word
+// "points". The output is opened by the engine and passed as /dev/fd/3, so
+// the checker cannot be pointed at a host file through a symlink in the box.
+func (h *Handler) CustomCheck(ctx context.Context, files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string, name string) CheckResult {
+	outputPath, expectedOutputPath, shouldReturn := h.parseMeta(files, meta, maxTime, maxRSS, finalResult)
+	if shouldReturn {
+		return CheckResult{}
+	}
+
+	path, err := CheckerPath(h.Config.CheckerDir, name)
+	if err != nil {
+		log.Printf("Error finding checker: %v", err)
+		*finalResult = "ie"
+		return CheckResult{}
+	}
+
+	output, err := sandbox.OpenOutput(outputPath)
+	if errors.Is(err, sandbox.ErrNotRegular) {
+		log.Printf("Warning: rejecting output %v", err)
+		*finalResult = "wa"
+		return CheckResult{}
+	}
+	if err != nil {
+		log.Printf("Error opening output: %v", err)
+		*finalResult = "ie"
+		return CheckResult{}
+	}
+	defer output.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, h.Config.CheckerTimeout)
+	defer cancel()
+
+	var stderr firstLineWriter
+	cmd := exec.CommandContext(ctx, path, files.Input, "/dev/fd/3", expectedOutputPath)
+	cmd.ExtraFiles = []*os.File{output}
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = checkerWaitDelay
+	err = cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The checker exited successfully; a child it left behind still
+		// held stderr, which has been cut off.
+		err = nil
+	}
+
+	var exitErr *exec.ExitError
+	exitCode := 0
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		log.Printf("Error running checker %s: %v", name, err)
+		*finalResult = "ie"
+		return CheckResult{}
+	}
+
+	message := strings.TrimSpace(string(stderr.line))
+
+	switch exitCode {
+	case checkerOK:
+		*finalResult = "ac"
+		return CheckResult{Score: 1, Message: truncateMessage(message)}
+	case checkerWrongAnswer, checkerDirt:
+		*finalResult = "wa"
+	case checkerPresentationError:
+		*finalResult = "pe"
+	case checkerPoints:
+		score, rest, err := parsePoints(message)
+		if err != nil {
+			log.Printf("Checker %s: %v", name, err)
+			*finalResult = "ie"
+			return CheckResult{}
+		}
+		switch {
+		case score >= 1:
+			*finalResult = "ac"
+		case score <= 0:
+			*finalResult = "wa"
+		default:
+			*finalResult = "pc"
+		}
+		return CheckResult{Score: score, Message: truncateMessage(rest)}
+	case checkerFail:
+		log.Printf("Checker %s failed: %s", name, message)
+		*finalResult = "ie"
+		return CheckResult{}
+	default:
+		log.Printf("Checker %s exited with unknown code %d: %s", name, exitCode, message)
+		*finalResult = "ie"
+		return CheckResult{}
+	}
+	return CheckResult{Message: truncateMessage(message)}
+}
+
+// firstLineWriter keeps the first line written to it, up to
+// maxCheckerStderr bytes, and discards the rest, so a checker cannot fill
+// the engine's memory through stderr.
+type firstLineWriter struct {
+	line []byte
+	done bool
+}
+
+func (w *firstLineWriter) Write(p []byte) (int, error) {
+	if !w.done {
+		chunk, _, found := bytes.Cut(p, []byte("\n"))
+		chunk = chunk[:min(len(chunk), maxCheckerStderr-len(w.line))]
+		w.line = append(w.line, chunk...)
+		w.done = found || len(w.line) == maxCheckerStderr
+	}
+	return len(p), nil
+}
+
+// parsePoints splits "[points] <score> <message>".
+func parsePoints(message string) (float64, string, error) {
+	message = strings.TrimPrefix(message, "points ")
+	value, rest, _ := strings.Cut(strings.TrimSpace(message), " ")
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(score >= 0 && score <= 1) {
+		return 0, "", fmt.Errorf("invalid score %q, want a number in [0, 1]", value)
+	}
+	return score, strings.TrimSpace(rest), nil
+}
+
+func truncateMessage(message string) string {
+	if len(message) > maxCheckerMessage {
+		return message[:maxCheckerMessage] + "..."
+	}
+	return message
+}
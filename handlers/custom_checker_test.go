@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+// writeChecker installs a shell script as the checker "check" and returns a
+// handler that uses it.
+func writeChecker(t *testing.T, script string) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "check"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{Config: &config.Config{CheckerDir: dir, CheckerTimeout: 5 * time.Second}}
+}
+
+func TestCustomCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		score   float64
+		message string
+	}{
+		{"ok", "echo 'ok 3 numbers' >&2", "ac", 1, "ok 3 numbers"},
+		{"wrong answer", "echo 'expected 5, found 6' >&2; exit 1", "wa", 0, "expected 5, found 6"},
+		{"presentation error", "exit 2", "pe", 0, ""},
+		{"fail", "echo 'bad answer file' >&2; exit 3", "ie", 0, ""},
+		{"points", "echo 'points 0.25 path of length 12' >&2; exit 7", "pc", 0.25, "path of length 12"},
+		{"points without prefix", "echo '1' >&2; exit 7", "ac", 1, ""},
+		{"points out of range", "echo 'points 5' >&2; exit 7", "ie", 0, ""},
+		{"unknown exit code", "exit 9", "ie", 0, ""},
+		{"reads its arguments", `cmp -s "$2" "$3" || exit 1`, "ac", 1, ""},
+		{"arguments differ", `cmp -s "$1" "$3" || exit 1`, "wa", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := writeChecker(t, tt.script)
+			files := writeBox(t, "42\n", "42\n")
+			if err := os.WriteFile(files.Input, []byte("6 7\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var maxTime, maxRSS float32
+			result := "ac"
+			check := h.CustomCheck(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, "check")
+			if result != tt.want || check.Score != tt.score || check.Message != tt.message {
+				t.Errorf("got %s %v %q, want %s %v %q", result, check.Score, check.Message, tt.want, tt.score, tt.message)
+			}
+		})
+	}
+}
+
+func TestCustomCheckRejectsSymlinkedOutput(t *testing.T) {
+	h := writeChecker(t, `cat "$2" >&2`)
+	files := writeBox(t, "", "secret\n")
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(files.Output); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, files.Output); err != nil {
+		t.Fatal(err)
+	}
+
+	var maxTime, maxRSS float32
+	result := "ac"
+	check := h.CustomCheck(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, "check")
+	if result != "wa" || check.Message != "" {
+		t.Errorf("got %s %q, want wa without the linked file", result, check.Message)
+	}
+}
+
+func TestCustomCheckTimeout(t *testing.T) {
+	h := writeChecker(t, "exec sleep 10")
+	h.Config.CheckerTimeout = 100 * time.Millisecond
+	files := writeBox(t, "1\n", "1\n")
+	var maxTime, maxRSS float32
+	result := "ac"
+	h.CustomCheck(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, "check")
+	if result != "ie" {
+		t.Errorf("result = %q, want ie", result)
+	}
+}
+
+func TestCustomCheckBoundsStderr(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"long message", "head -c 1000000 /dev/zero | tr '\\0' x >&2; exit 1", "wa"},
+		{"child holds stderr", "sleep 10 >&2 & exit 0", "ac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := writeChecker(t, tt.script)
+			files := writeBox(t, "1\n", "1\n")
+			var maxTime, maxRSS float32
+			result := "ie"
+			start := time.Now()
+			check := h.CustomCheck(context.Background(), files, &sandbox.Result{}, &maxTime, &maxRSS, &result, "check")
+			if result != tt.want || len(check.Message) > maxCheckerMessage+len("...") {
+				t.Errorf("got %s with a %d byte message, want %s", result, len(check.Message), tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestCheckerPath(t *testing.T) {
+	h := writeChecker(t, "exit 0")
+	dir := h.Config.CheckerDir
+	if err := os.WriteFile(filepath.Join(dir, "data"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CheckerPath(dir, "check"); err != nil {
+		t.Errorf("CheckerPath(check) = %v", err)
+	}
+	for _, name := range []string{"", "../check", ".hidden", "missing", "data"} {
+		if _, err := CheckerPath(dir, name); err == nil {
+			t.Errorf("CheckerPath(%q) succeeded", name)
+		}
+	}
+	if _, err := CheckerPath("", "check"); err == nil {
+		t.Error("CheckerPath without a checker_dir succeeded")
+	}
+}
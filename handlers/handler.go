@@ -1,13 +1,36 @@
 package handlers
 
-import "github.com/judgenot0/judge-deamon/config"
+import (
+	"net/http"
+
+	"github.com/judgenot0/judge-deamon/artifacts"
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/testcases"
+)
 
 type Handler struct {
-	Config *config.Config
+	Config  *config.Config
+	Sandbox sandbox.Sandbox
+	// Testcases caches referenced testcase files. It outlives reloads, so
+	// it is set by the caller rather than NewHandler.
+	Testcases *testcases.Store
+	// Artifacts caches compiled submissions, and likewise outlives reloads.
+	Artifacts  *artifacts.Store
+	httpClient *http.Client
 }
 
 func NewHandler(config *config.Config) *Handler {
 	return &Handler{
-		Config: config,
+		Config:  config,
+		Sandbox: sandbox.New(config),
+		httpClient: &http.Client{
+			Timeout: config.VerdictTimeout,
+		},
 	}
 }
+
+// BoxPath returns the host directory of a sandbox box.
+func (h *Handler) BoxPath(boxId int) string {
+	return h.Sandbox.BoxPath(boxId)
+}
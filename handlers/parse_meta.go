@@ -3,82 +3,44 @@ package handlers
 import (
 	"log"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"syscall"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
 )
 
-func (h *Handler) parseMeta(boxPath string, maxTime *float32, maxRSS *float32, finalResult *string) (outputPath, expectedOutputPath string, shouldReturn bool) {
-	metaPath := filepath.Join(boxPath, "meta.txt")
-	outputPath = filepath.Join(boxPath, "out.txt")
-	expectedOutputPath = filepath.Join(boxPath, "expOut.txt")
+// TestFiles are the host paths of one test's files. Only the output and
+// stderr are in the box; the expected output is kept where the program
+// cannot read it.
+type TestFiles struct {
+	Input    string
+	Output   string
+	Stderr   string
+	Expected string
+	LimitKB  int // largest file the program may write; 0 if unknown
+}
 
-	metaContent, err := os.ReadFile(metaPath)
-	if err != nil {
-		log.Printf("Error reading meta file: %v", err)
-		*finalResult = "ie"
-		return "", "", true
+// reachedLimit reports whether the program's output or stderr grew to the
+// file size limit. Runtimes that ignore SIGXFSZ, like CPython, get EFBIG
+// instead and usually exit with an error, which would look like "re".
+func (files TestFiles) reachedLimit() bool {
+	if files.LimitKB <= 0 {
+		return false
 	}
-
-	var meta Meta
-	for _, line := range strings.Split(string(metaContent), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
+	for _, path := range []string{files.Output, files.Stderr} {
+		if path == "" {
 			continue
 		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		switch key {
-		case "status":
-			meta.Status = value
-		case "message":
-			meta.Message = value
-		case "killed":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.Killed = v
-			}
-		case "exitcode":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.ExitCode = v
-			}
-		case "exitsig":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.ExitSig = v
-			}
-		case "time":
-			if v, err := strconv.ParseFloat(value, 32); err == nil {
-				meta.Time = float32(v)
-			}
-		case "time-wall":
-			if v, err := strconv.ParseFloat(value, 32); err == nil {
-				meta.Time_Wall = float32(v)
-			}
-		case "max-rss":
-			if v, err := strconv.ParseFloat(value, 32); err == nil {
-				meta.Max_RSS = float32(v)
-			}
-		case "cg-mem":
-			if v, err := strconv.ParseFloat(value, 32); err == nil {
-				meta.CG_Mem = float32(v)
-			}
-		case "cg-oom-killed":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.CG_OOM_Killed = v
-			}
-		case "csw-voluntary":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.CSW_Voluntary = v
-			}
-		case "csw-forced":
-			if v, err := strconv.Atoi(value); err == nil {
-				meta.CSW_Forced = v
-			}
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode().IsRegular() && info.Size() >= int64(files.LimitKB)*1024 {
+			return true
 		}
 	}
+	return false
+}
+
+func (h *Handler) parseMeta(files TestFiles, meta *sandbox.Result, maxTime *float32, maxRSS *float32, finalResult *string) (outputPath, expectedOutputPath string, shouldReturn bool) {
+	outputPath = files.Output
+	expectedOutputPath = files.Expected
 
 	if meta.Time > *maxTime {
 		*maxTime = meta.Time
@@ -93,7 +55,14 @@ func (h *Handler) parseMeta(boxPath string, maxTime *float32, maxRSS *float32, f
 		return "", "", true
 	}
 
-	// Priority 2: Check if killed by sandbox (time/memory limit)
+	// Priority 2: SIGXFSZ, or stdout or stderr at the limit, means the
+	// program hit the output limit
+	if meta.ExitSig == int(syscall.SIGXFSZ) || files.reachedLimit() {
+		*finalResult = "ole"
+		return "", "", true
+	}
+
+	// Priority 3: Check if killed by sandbox (time/memory limit)
 	if meta.Killed == 1 {
 		// If killed is present, check the status to determine why
 		if meta.Status == "TO" {
@@ -103,7 +72,7 @@ func (h *Handler) parseMeta(boxPath string, maxTime *float32, maxRSS *float32, f
 		// Other kill reasons would fall through to status check
 	}
 
-	// Priority 3: Check status codes
+	// Priority 4: Check status codes
 	if meta.Status != "" {
 		switch meta.Status {
 		case "RE":
@@ -118,7 +87,7 @@ func (h *Handler) parseMeta(boxPath string, maxTime *float32, maxRSS *float32, f
 		return "", "", true
 	}
 
-	// Priority 4: Check for non-zero exit code (runtime error without status)
+	// Priority 5: Check for non-zero exit code (runtime error without status)
 	// This handles cases where program exits with error but no status is set
 	if meta.ExitCode != 0 {
 		*finalResult = "re"
@@ -126,7 +95,7 @@ func (h *Handler) parseMeta(boxPath string, maxTime *float32, maxRSS *float32, f
 	}
 
 	// All checks passed - program executed successfully, proceed to output comparison
-	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
+	if _, err := os.Lstat(outputPath); os.IsNotExist(err) {
 		log.Printf("Output file does not exist: %s", outputPath)
 		*finalResult = "ie"
 		return "", "", true
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/sandbox/sandboxtest"
+)
+
+func newTestHandler() *Handler {
+	return &Handler{Config: &config.Config{}}
+}
+
+// writeBox writes the program's output and the expected output of a test.
+// The input is left for the test to write if it needs one.
+func writeBox(t *testing.T, output, expected string) TestFiles {
+	t.Helper()
+	dir := t.TempDir()
+	files := TestFiles{
+		Input:    filepath.Join(dir, "in.txt"),
+		Output:   filepath.Join(dir, "out.txt"),
+		Expected: filepath.Join(dir, "expected.txt"),
+	}
+	if err := os.WriteFile(files.Output, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files.Expected, []byte(expected), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestParseMetaClassification(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome sandboxtest.Outcome
+		want    string
+		proceed bool
+	}{
+		{"clean exit", sandboxtest.Accepted(""), "", true},
+		{"time limit", sandboxtest.TimeLimit(), "tle", false},
+		{"out of memory", sandboxtest.OutOfMemory(), "mle", false},
+		{"segfault", sandboxtest.Signal(11), "re", false},
+		{"non-zero exit", sandboxtest.Exit(1), "re", false},
+		{"output limit", sandboxtest.OutputLimit(), "ole", false},
+		{"sandbox error", sandboxtest.SandboxError("cannot run proxy"), "ie", false},
+		{"exit code without status", sandboxtest.Outcome{Meta: "exitcode:3\n"}, "re", false},
+		{"wall time", sandboxtest.Outcome{Meta: "status:TO\nmessage:Time limit exceeded (wall clock)\nkilled:1\n"}, "tle", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := writeBox(t, "", "")
+			var maxTime, maxRSS float32
+			result := ""
+
+			meta := sandbox.ParseMeta([]byte(tt.outcome.Meta))
+			_, _, shouldReturn := newTestHandler().parseMeta(files, meta, &maxTime, &maxRSS, &result)
+			if shouldReturn == tt.proceed {
+				t.Fatalf("shouldReturn = %v, want %v", shouldReturn, !tt.proceed)
+			}
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetaOutputAtLimit(t *testing.T) {
+	// CPython ignores SIGXFSZ: the write fails with EFBIG and the program
+	// exits with an error.
+	for _, file := range []string{"out.txt", "err.txt"} {
+		t.Run(file, func(t *testing.T) {
+			files := writeBox(t, "", "")
+			files.Stderr = filepath.Join(filepath.Dir(files.Output), "err.txt")
+			files.LimitKB = 1
+			if err := os.WriteFile(filepath.Join(filepath.Dir(files.Output), file), make([]byte, 1024), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var maxTime, maxRSS float32
+			result := ""
+
+			meta := sandbox.ParseMeta([]byte(sandboxtest.Exit(1).Meta))
+			_, _, shouldReturn := newTestHandler().parseMeta(files, meta, &maxTime, &maxRSS, &result)
+			if !shouldReturn || result != "ole" {
+				t.Errorf("got shouldReturn=%v result=%q, want ole", shouldReturn, result)
+			}
+		})
+	}
+
+	files := writeBox(t, "short\n", "")
+	files.LimitKB = 1
+	var maxTime, maxRSS float32
+	result := ""
+	newTestHandler().parseMeta(files, sandbox.ParseMeta([]byte(sandboxtest.Exit(1).Meta)), &maxTime, &maxRSS, &result)
+	if result != "re" {
+		t.Errorf("result = %q below the limit, want re", result)
+	}
+}
+
+func TestParseMetaTracksMaximums(t *testing.T) {
+	files := writeBox(t, "", "")
+	maxTime, maxRSS := float32(0.5), float32(4096)
+	result := ""
+
+	h := newTestHandler()
+	h.parseMeta(files, &sandbox.Result{Time: 0.2, Max_RSS: 8192}, &maxTime, &maxRSS, &result)
+	if maxTime != 0.5 || maxRSS != 8192 {
+		t.Errorf("maxTime, maxRSS = %v, %v, want 0.5, 8192", maxTime, maxRSS)
+	}
+}
+
+func TestParseMetaMissingOutput(t *testing.T) {
+	var maxTime, maxRSS float32
+	result := ""
+	_, _, shouldReturn := newTestHandler().parseMeta(TestFiles{Output: filepath.Join(t.TempDir(), "out.txt")}, &sandbox.Result{}, &maxTime, &maxRSS, &result)
+	if !shouldReturn || result != "ie" {
+		t.Errorf("got shouldReturn=%v result=%q, want ie", shouldReturn, result)
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/judgenot0/judge-deamon/compare"
+)
+
+// checkPresentation changes a wrong answer into a presentation error when
+// the output matches under opts, a token mode that ignores spacing and line
+// breaks.
+func checkPresentation(outputPath, expectedOutputPath string, opts compare.Options, finalResult *string) {
+	mismatch, err := compareOutput(outputPath, expectedOutputPath, opts)
+	if err != nil {
+		log.Printf("Error comparing output: %v", err)
+		return
+	}
+	if mismatch == nil {
+		*finalResult = "pe"
+	}
+}
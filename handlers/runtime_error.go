@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+	syscall.SIGSYS:  "SIGSYS",
+}
+
+var signalHints = map[syscall.Signal]string{
+	syscall.SIGILL:  "illegal instruction; a non-void function without a return, or a corrupted stack",
+	syscall.SIGTRAP: "trap instruction; often a compiler-inserted check such as __builtin_trap",
+	syscall.SIGABRT: "aborted; a failed assertion, an uncaught C++ exception or std::bad_alloc, or heap corruption",
+	syscall.SIGBUS:  "invalid memory access; misaligned or unmapped address",
+	syscall.SIGFPE:  "arithmetic error, most likely integer division by zero or modulo by zero",
+	syscall.SIGKILL: "killed by the sandbox, usually for exceeding a resource limit",
+	syscall.SIGSEGV: "invalid memory access; stack overflow from deep recursion, an out-of-bounds index or a null pointer likely",
+	syscall.SIGPIPE: "wrote to a closed pipe",
+	syscall.SIGSYS:  "forbidden system call",
+}
+
+// RuntimeErrorDetails explains why a run ended in a runtime error, from the
+// signal or exit code in meta.
+func RuntimeErrorDetails(meta *sandbox.Result) *structs.RuntimeError {
+	details := &structs.RuntimeError{
+		ExitCode: meta.ExitCode,
+		Message:  meta.Message,
+	}
+
+	if meta.ExitSig != 0 {
+		sig := syscall.Signal(meta.ExitSig)
+		details.Signal = signalNames[sig]
+		if details.Signal == "" {
+			details.Signal = fmt.Sprintf("signal %d", meta.ExitSig)
+		}
+		details.Hint = signalHints[sig]
+		return details
+	}
+
+	if meta.ExitCode != 0 {
+		details.Hint = fmt.Sprintf("exited with status %d; an uncaught exception, or main returning non-zero", meta.ExitCode)
+	}
+	return details
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+func TestRuntimeErrorDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		meta       sandbox.Result
+		signal     string
+		exitCode   int
+		hintSubstr string
+	}{
+		{"segfault", sandbox.Result{Status: "SG", ExitSig: 11}, "SIGSEGV", 0, "stack overflow"},
+		{"division by zero", sandbox.Result{Status: "SG", ExitSig: 8}, "SIGFPE", 0, "division by zero"},
+		{"abort", sandbox.Result{Status: "SG", ExitSig: 6}, "SIGABRT", 0, "assertion"},
+		{"killed", sandbox.Result{Status: "SG", ExitSig: 9}, "SIGKILL", 0, "resource limit"},
+		{"unknown signal", sandbox.Result{Status: "SG", ExitSig: 40}, "signal 40", 0, ""},
+		{"exit code", sandbox.Result{Status: "RE", ExitCode: 3}, "", 3, "status 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.meta.Message = "from isolate"
+			details := RuntimeErrorDetails(&tt.meta)
+			if details.Signal != tt.signal || details.ExitCode != tt.exitCode {
+				t.Errorf("signal, exit code = %q, %d, want %q, %d", details.Signal, details.ExitCode, tt.signal, tt.exitCode)
+			}
+			if !strings.Contains(details.Hint, tt.hintSubstr) {
+				t.Errorf("hint %q does not mention %q", details.Hint, tt.hintSubstr)
+			}
+			if details.Message != "from isolate" {
+				t.Errorf("message = %q", details.Message)
+			}
+		})
+	}
+}
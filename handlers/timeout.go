@@ -0,0 +1,21 @@
+package handlers
+
+import "github.com/judgenot0/judge-deamon/sandbox"
+
+// idleCPUShare is the share of wall time below which a run that hit the
+// wall-clock limit counts as idle: blocked on input or sleeping rather than
+// computing.
+const idleCPUShare = 0.25
+
+// TimeoutVerdict tells apart why a run was stopped for time: "tle" when it
+// used its CPU time, "ile" when it mostly waited, and "wtle" when it was
+// busy but still ran out of wall-clock time.
+func TimeoutVerdict(meta *sandbox.Result, cpuLimit float32) string {
+	if meta.Time >= cpuLimit {
+		return "tle"
+	}
+	if meta.Time < idleCPUShare*meta.Time_Wall {
+		return "ile"
+	}
+	return "wtle"
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+func TestTimeoutVerdict(t *testing.T) {
+	tests := []struct {
+		name      string
+		cpu, wall float32
+		want      string
+	}{
+		{"used its cpu time", 1.02, 1.10, "tle"},
+		{"blocked on input", 0.01, 1.50, "ile"},
+		{"sleeping", 0.30, 1.50, "ile"},
+		{"busy but slow", 0.90, 1.50, "wtle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := &sandbox.Result{Status: "TO", Killed: 1, Time: tt.cpu, Time_Wall: tt.wall}
+			if got := TimeoutVerdict(meta, 1); got != tt.want {
+				t.Errorf("TimeoutVerdict() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
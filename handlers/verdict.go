@@ -17,11 +17,15 @@ import (
 )
 
 type EngineData struct {
-	SubmissionId    int64    `json:"submission_id"`
-	Verdict         string   `json:"verdict"`
-	ExecutionTime   *float32 `json:"execution_time"`
-	ExecutionMemory *float32 `json:"execution_memory"`
-	Timestamp       int64    `json:"timestamp"`
+	SubmissionId    int64                 `json:"submission_id"`
+	Verdict         string                `json:"verdict"`
+	ExecutionTime   *float32              `json:"execution_time"`
+	ExecutionMemory *float32              `json:"execution_memory"`
+	RuntimeError    *structs.RuntimeError `json:"runtime_error,omitempty"`
+	WrongAnswer     *structs.WrongAnswer  `json:"wrong_answer,omitempty"`
+	Score           *float64              `json:"score,omitempty"`
+	Tests           []structs.TestResult  `json:"tests,omitempty"`
+	Timestamp       int64                 `json:"timestamp"`
 }
 
 type EnginePayload struct {
@@ -29,18 +33,9 @@ type EnginePayload struct {
 	AccessToken string      `json:"access_token"`
 }
 
-var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
-}
-
-func GenerateToken(submissionId int64, verdict string, execTime, execMem *float32, secret string) (*EnginePayload, error) {
-	data := &EngineData{
-		SubmissionId:    submissionId,
-		Verdict:         verdict,
-		ExecutionTime:   execTime,
-		ExecutionMemory: execMem,
-		Timestamp:       time.Now().Unix(),
-	}
+// GenerateToken stamps data with the current time and signs it with secret.
+func GenerateToken(data *EngineData, secret string) (*EnginePayload, error) {
+	data.Timestamp = time.Now().Unix()
 
 	message, err := json.Marshal(data)
 	if err != nil {
@@ -75,13 +70,16 @@ func (h *Handler) ProduceVerdict(verdict *structs.Verdict, ackStatus *bool) {
 		return
 	}
 
-	payload, err := GenerateToken(
-		*(verdict.Submission.SubmissionId),
-		verdict.Result,
-		verdict.MaxTime,
-		verdict.MaxRSS,
-		h.Config.EngineKey,
-	)
+	payload, err := GenerateToken(&EngineData{
+		SubmissionId:    *(verdict.Submission.SubmissionId),
+		Verdict:         verdict.Result,
+		ExecutionTime:   verdict.MaxTime,
+		ExecutionMemory: verdict.MaxRSS,
+		RuntimeError:    verdict.RuntimeError,
+		WrongAnswer:     verdict.WrongAnswer,
+		Score:           verdict.Score,
+		Tests:           verdict.Tests,
+	}, h.Config.EngineKey)
 	if err != nil {
 		log.Println("Error generating token:", err)
 		verdict.Result = "ie"
@@ -106,7 +104,7 @@ func (h *Handler) ProduceVerdict(verdict *structs.Verdict, ackStatus *bool) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+payload.AccessToken)
 
-	resp, err := httpClient.Do(req)
+	resp, err := h.httpClient.Do(req)
 	if err != nil {
 		log.Println("Error sending PATCH request:", err)
 		*ackStatus = false
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+// WrongAnswerDetails reports where the output first differed from the
+// expected one. Only submissions with checker_diagnostics get it, so
+// expected output never leaks into contest verdicts.
+func WrongAnswerDetails(mismatch *compare.Mismatch) *structs.WrongAnswer {
+	if mismatch == nil {
+		return nil
+	}
+	return &structs.WrongAnswer{
+		Line:         mismatch.Line,
+		ExpectedLine: mismatch.ExpectedLine,
+		Column:       mismatch.Column,
+		Token:        mismatch.Token,
+		Output:       mismatch.Output,
+		Expected:     mismatch.Expected,
+		Reason:       string(mismatch.Kind),
+		Message:      mismatch.String(),
+	}
+}
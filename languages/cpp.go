@@ -1,135 +1,23 @@
 package languages
 
-import (
-	"context"
-	"errors"
-	"fmt"
-	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
-
-	"github.com/judgenot0/judge-deamon/handlers"
-	"github.com/judgenot0/judge-deamon/structs"
-)
-
-type CPP struct {
-}
-
-func (p *CPP) Compile(ctx context.Context, boxId int, submission *structs.Submission) (structs.Verdict, error) {
-	code := submission.SourceCode
-
-	boxPath := fmt.Sprintf("/var/local/lib/isolate/%d/box/", boxId)
-
-	cppFilePath := filepath.Join(boxPath, "main.cpp")
-	if err := os.WriteFile(cppFilePath, []byte(code), 0644); err != nil {
-		log.Printf("Error writing code to file: %v", err)
-		return structs.Verdict{}, err
-	}
-
-	outputBinary := filepath.Join(boxPath, "main")
-
-	output, err := exec.CommandContext(ctx,
-		"g++",
-		"--std=gnu++23",
-		"-O2",
-		"-pipe",
-		"-s",
-		"-w",
-		cppFilePath,
-		"-o", outputBinary,
-		"-lm",
-	).CombinedOutput()
-
-	if err != nil {
-		log.Printf("Compilation error: %v, output: %s", err, string(output))
-		return structs.Verdict{
-			Submission: submission,
-			Result:     "ce",
-			MaxTime:    nil,
-			MaxRSS:     nil,
-		}, errors.New("compilation error")
-	}
-
-	if _, err := os.Stat(outputBinary); os.IsNotExist(err) {
-		log.Printf("Compilation succeeded but binary not found: %s", outputBinary)
-		return structs.Verdict{
-			Submission: submission,
-			Result:     "ce",
-			MaxTime:    nil,
-			MaxRSS:     nil,
-		}, errors.New("binary not created")
-	}
-
-	return structs.Verdict{}, nil
-}
-
-func (p *CPP) Run(ctx context.Context, boxId int, submission *structs.Submission, handler *handlers.Handler) structs.Verdict {
-	boxPath := fmt.Sprintf("/var/local/lib/isolate/%d/box/", boxId)
-
-	var maxTime float32
-	var maxRSS float32
-	finalResult := "ac"
-
-	inputPath := filepath.Join(boxPath, "in.txt")
-	expectedOutputPath := filepath.Join(boxPath, "expOut.txt")
-	outputPath := filepath.Join(boxPath, "out.txt")
-	metaPath := filepath.Join(boxPath, "meta.txt")
-
-	for _, test := range submission.Testcases {
-		input := test.Input
-		output := test.ExpectedOutput
-
-		if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
-			log.Printf("Error writing input file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		if err := os.WriteFile(expectedOutputPath, []byte(output), 0644); err != nil {
-			log.Printf("Error writing expected output file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		if err := os.WriteFile(outputPath, []byte(""), 0644); err != nil {
-			log.Printf("Error writing output file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		memLimit := submission.MemoryLimit * 1024
-		isolateCmd := exec.CommandContext(ctx, "isolate",
-			fmt.Sprintf("--box-id=%d", boxId),
-			"--cg",
-			"--stdin=in.txt",
-			"--stdout=out.txt",
-			fmt.Sprintf("--time=%.3f", submission.TimeLimit),
-			fmt.Sprintf("--wall-time=%.3f", (submission.TimeLimit)*1.5),
-			"--fsize=10240",
-			fmt.Sprintf("--cg-mem=%d", int(memLimit)),
-			fmt.Sprintf("--meta=%s", metaPath),
-			"--run",
-			"--",
-			"./main",
-		)
-
-		_ = isolateCmd.Run()
-		switch submission.CheckerType {
-		case "float":
-			handler.CompareFloat(boxPath, &maxTime, &maxRSS, &finalResult, submission.CheckerStrictSpace, submission.CheckerPrecision)
-		default:
-			handler.Compare(boxPath, &maxTime, &maxRSS, &finalResult, submission.CheckerStrictSpace)
-		}
-
-		if finalResult != "ac" {
-			break
-		}
-	}
-	return structs.Verdict{
-		Submission: submission,
-		Result:     finalResult,
-		MaxTime:    &maxTime,
-		MaxRSS:     &maxRSS,
+import "github.com/judgenot0/judge-deamon/config"
+
+func cppDefinition() config.Language {
+	return config.Language{
+		Name:       "cpp",
+		SourceFile: "main.cpp",
+		Compile: []string{
+			"g++",
+			"--std=gnu++23",
+			"-O2",
+			"-pipe",
+			"-s",
+			"-w",
+			"main.cpp",
+			"-o", "main",
+			"-lm",
+		},
+		Binary: "main",
+		Run:    []string{"./main"},
 	}
 }
@@ -0,0 +1,371 @@
+package languages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/artifacts"
+	"github.com/judgenot0/judge-deamon/compare"
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/handlers"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+	"github.com/judgenot0/judge-deamon/testcases"
+)
+
+// Language compiles and runs submissions according to its definition.
+type Language struct {
+	def config.Language
+
+	mu              sync.Mutex
+	compilerStamp   string // path, size and mtime of the compiler
+	compilerVersion string // its --version output
+}
+
+func (l *Language) Name() string {
+	return l.def.Name
+}
+
+// Compile builds the submission in dir, a workspace on the host that Run
+// copies into the boxes.
+func (l *Language) Compile(ctx context.Context, dir string, submission *structs.Submission, handler *handlers.Handler) (structs.Verdict, error) {
+	if err := os.WriteFile(filepath.Join(dir, l.def.SourceFile), []byte(submission.SourceCode), 0644); err != nil {
+		log.Printf("Error writing code to file: %v", err)
+		return structs.Verdict{}, err
+	}
+
+	if len(l.def.Compile) == 0 {
+		return structs.Verdict{}, nil
+	}
+
+	key := artifacts.Key(append([]string{l.def.Name, l.compilerIdentity(ctx), l.def.SourceFile, l.def.Binary, submission.SourceCode}, l.def.Compile...)...)
+	if handler.Artifacts.Restore(key, dir) {
+		return structs.Verdict{}, nil
+	}
+
+	cmd := exec.CommandContext(ctx, l.def.Compile[0], l.def.Compile[1:]...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Compilation error: %v, output: %s", err, string(output))
+		return structs.Verdict{
+			Submission: submission,
+			Result:     "ce",
+			MaxTime:    nil,
+			MaxRSS:     nil,
+		}, errors.New("compilation error")
+	}
+
+	if l.def.Binary != "" {
+		binaryPath := filepath.Join(dir, l.def.Binary)
+		if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
+			log.Printf("Compilation succeeded but binary not found: %s", binaryPath)
+			return structs.Verdict{
+				Submission: submission,
+				Result:     "ce",
+				MaxTime:    nil,
+				MaxRSS:     nil,
+			}, errors.New("binary not created")
+		}
+	}
+
+	if err := handler.Artifacts.Save(key, dir); err != nil {
+		log.Printf("Error caching compiled submission: %v", err)
+	}
+	return structs.Verdict{}, nil
+}
+
+// compilerIdentity describes the compiler for artifact keys. The binary's
+// size and modification time change with an upgrade, which also refreshes
+// the version.
+func (l *Language) compilerIdentity(ctx context.Context) string {
+	path, err := exec.LookPath(l.def.Compile[0])
+	if err != nil {
+		return l.def.Compile[0]
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	stamp := fmt.Sprintf("%s %d %d", path, info.Size(), info.ModTime().UnixNano())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.compilerStamp != stamp {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		// Not every compiler knows --version; its complaint is stable too.
+		version, _ := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+		l.compilerStamp, l.compilerVersion = stamp, string(version)
+	}
+	return l.compilerStamp + "\n" + l.compilerVersion
+}
+
+// Run copies the submission compiled in dir into the boxes and judges it.
+// With several boxes the tests are shared out; the verdict is the same as
+// running them one by one in order.
+func (l *Language) Run(ctx context.Context, dir string, boxIds []int, submission *structs.Submission, handler *handlers.Handler) structs.Verdict {
+	wallTimeMultiplier := handler.Config.WallTimeMultiplier
+	if l.def.WallTimeMultiplier > 0 {
+		wallTimeMultiplier = l.def.WallTimeMultiplier
+	}
+
+	tolerance, err := handlers.FloatTolerance(submission)
+	if err != nil {
+		log.Printf("Invalid float checker settings: %v", err)
+		return structs.Verdict{Submission: submission, Result: "ie"}
+	}
+
+	outputLimitKB := handler.Config.FsizeKB
+	if submission.OutputLimit > 0 {
+		outputLimitKB = int(submission.OutputLimit * 1024)
+	}
+
+	spec := sandbox.RunSpec{
+		Limits: sandbox.Limits{
+			Time:      submission.TimeLimit,
+			WallTime:  submission.TimeLimit * wallTimeMultiplier,
+			MemoryKB:  int(submission.MemoryLimit * 1024),
+			FsizeKB:   outputLimitKB,
+			Processes: l.def.Processes,
+		},
+		Stdin:  testcases.InputFile,
+		Stdout: "out.txt",
+		Stderr: "err.txt",
+		Argv:   l.def.Run,
+	}
+
+	var lanes []lane
+	for i, id := range boxIds {
+		if err := sandbox.CopyDir(dir, handler.BoxPath(id)); err != nil {
+			log.Printf("Error copying submission to sandbox %d: %v", id, err)
+			if i == 0 {
+				return structs.Verdict{Submission: submission, Result: "ie"}
+			}
+			// A borrowed box that cannot take the copy is simply not used.
+			continue
+		}
+		lanes = append(lanes, lane{boxId: id})
+	}
+	for i := range lanes {
+		stage, err := handler.Testcases.NewStage()
+		if err != nil {
+			log.Printf("Error staging testcases: %v", err)
+			return structs.Verdict{Submission: submission, Result: "ie"}
+		}
+		defer stage.Close()
+		lanes[i].stage = stage
+	}
+
+	runs := l.runTests(ctx, lanes, spec, submission, handler, tolerance)
+
+	var maxTime float32
+	var maxRSS float32
+	finalResult := "ac"
+	var tests []structs.TestResult
+	var runtimeError *structs.RuntimeError
+	var wrongAnswer *structs.WrongAnswer
+	var score float64
+	for _, run := range runs {
+		if run == nil {
+			// skipped after an earlier failure
+			break
+		}
+		maxTime = max(maxTime, run.maxTime)
+		maxRSS = max(maxRSS, run.maxRSS)
+		tests = append(tests, run.result)
+		score += run.result.Score
+
+		testVerdict := run.result.Result
+		if testVerdict == "ac" {
+			continue
+		}
+		if testVerdict == "ie" {
+			finalResult = "ie"
+			break
+		}
+		// The first failing test decides the verdict; with partial scoring
+		// the remaining tests still run for their score.
+		if finalResult == "ac" {
+			finalResult = testVerdict
+			runtimeError = run.result.RuntimeError
+			wrongAnswer = run.result.WrongAnswer
+		}
+		if !submission.PartialScoring {
+			break
+		}
+	}
+
+	verdict := structs.Verdict{
+		Submission: submission,
+		Result:     finalResult,
+		MaxTime:    &maxTime,
+		MaxRSS:     &maxRSS,
+
+		RuntimeError: runtimeError,
+		WrongAnswer:  wrongAnswer,
+		Tests:        tests,
+	}
+	if submission.PartialScoring && finalResult != "ie" {
+		total := score / float64(len(submission.Testcases))
+		verdict.Score = &total
+	}
+	return verdict
+}
+
+// lane is a box running tests, with the stage its inputs are mounted from.
+type lane struct {
+	boxId int
+	stage *testcases.Stage
+}
+
+// testRun is the outcome of one test.
+type testRun struct {
+	result  structs.TestResult
+	maxTime float32
+	maxRSS  float32
+}
+
+// runTests hands the tests out in order to the lanes and returns their
+// outcomes by index. Once a test fails, later tests are no longer needed
+// unless partial scoring wants their score: those not started are left nil
+// and running ones are cancelled. Earlier tests still finish, since the
+// first failure in test order decides the verdict.
+func (l *Language) runTests(ctx context.Context, lanes []lane, spec sandbox.RunSpec, submission *structs.Submission, handler *handlers.Handler, tolerance compare.Tolerance) []*testRun {
+	runs := make([]*testRun, len(submission.Testcases))
+
+	var mu sync.Mutex
+	next := 0
+	needed := len(runs) // tests from here on are not needed
+	cancels := make(map[int]context.CancelFunc)
+
+	take := func() (int, context.Context, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if next >= needed {
+			return 0, nil, false
+		}
+		i := next
+		next++
+		testCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		return i, testCtx, true
+	}
+	finish := func(i int, run *testRun) {
+		mu.Lock()
+		defer mu.Unlock()
+		cancels[i]()
+		delete(cancels, i)
+		if i >= needed {
+			return
+		}
+		runs[i] = run
+		verdict := run.result.Result
+		if verdict == "ie" || verdict != "ac" && !submission.PartialScoring {
+			needed = i + 1
+			for j, cancel := range cancels {
+				if j > i {
+					cancel()
+				}
+			}
+		}
+	}
+
+	// A panic stops every lane and is raised again in the caller, where the
+	// job's recovery handles it.
+	var panicked any
+	stop := func(r any) {
+		mu.Lock()
+		defer mu.Unlock()
+		if panicked == nil {
+			panicked = r
+		}
+		needed = 0
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, ln := range lanes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					stop(r)
+				}
+			}()
+			for {
+				i, testCtx, ok := take()
+				if !ok {
+					return
+				}
+				finish(i, l.runTest(testCtx, ln, spec, i, &submission.Testcases[i], submission, handler, tolerance))
+			}
+		}()
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	return runs
+}
+
+// runTest runs test i in the lane's box and checks its output.
+func (l *Language) runTest(ctx context.Context, ln lane, spec sandbox.RunSpec, i int, test *structs.Testcase, submission *structs.Submission, handler *handlers.Handler, tolerance compare.Tolerance) *testRun {
+	run := &testRun{result: structs.TestResult{Index: i, Result: "ie"}}
+
+	input, expected, err := ln.stage.Put(test)
+	if err != nil {
+		log.Printf("Error staging testcase %d: %v", i, err)
+		return run
+	}
+	spec.InputDir = ln.stage.InputDir()
+
+	result, err := handler.Sandbox.Run(ctx, ln.boxId, spec)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error running submission in sandbox %d: %v", ln.boxId, err)
+		}
+		return run
+	}
+
+	testVerdict := "ac"
+	box := handler.BoxPath(ln.boxId)
+	files := handlers.TestFiles{
+		Input:    input,
+		Output:   filepath.Join(box, spec.Stdout),
+		Stderr:   filepath.Join(box, spec.Stderr),
+		Expected: expected,
+		LimitKB:  spec.FsizeKB,
+	}
+	check := handler.Check(ctx, files, result, &run.maxTime, &run.maxRSS, &testVerdict, submission, tolerance)
+
+	if testVerdict == "tle" {
+		testVerdict = handlers.TimeoutVerdict(result, spec.Time)
+	}
+
+	run.result = structs.TestResult{
+		Index:   i,
+		Result:  testVerdict,
+		Time:    result.Time,
+		Memory:  result.Max_RSS,
+		Score:   check.Score,
+		Message: check.Message,
+	}
+	if testVerdict == "re" {
+		run.result.RuntimeError = handlers.RuntimeErrorDetails(result)
+	}
+	if (testVerdict == "wa" || testVerdict == "pe") && submission.CheckerDiagnostics {
+		run.result.WrongAnswer = handlers.WrongAnswerDetails(check.Mismatch)
+	}
+	return run
+}
@@ -1,149 +1,31 @@
 package languages
 
 import (
-	"context"
-	"errors"
-	"fmt"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
 
-	"github.com/judgenot0/judge-deamon/handlers"
-	"github.com/judgenot0/judge-deamon/structs"
+	"github.com/judgenot0/judge-deamon/config"
 )
 
-type NodeJS struct {
-}
-
-func resolveNodeBinary() (string, error) {
+func resolveNodeBinary() string {
 	if _, err := os.Stat("/usr/bin/node"); err == nil {
-		return "/usr/bin/node", nil
+		return "/usr/bin/node"
 	}
 
 	if _, err := os.Stat("/usr/bin/nodejs"); err == nil {
-		return "/usr/bin/nodejs", nil
-	}
-
-	return "", errors.New("system node executable not found at /usr/bin/node or /usr/bin/nodejs")
-}
-
-func (p *NodeJS) Compile(ctx context.Context, boxId int, submission *structs.Submission) (structs.Verdict, error) {
-	code := submission.SourceCode
-	boxPath := fmt.Sprintf("/var/local/lib/isolate/%d/box/", boxId)
-	nodeBinary, err := resolveNodeBinary()
-	if err != nil {
-		log.Printf("Node.js executable not found: %v", err)
-		return structs.Verdict{
-			Submission: submission,
-			Result:     "ce",
-			MaxTime:    nil,
-			MaxRSS:     nil,
-		}, err
+		return "/usr/bin/nodejs"
 	}
 
-	jsFilePath := filepath.Join(boxPath, "main.js")
-	if err := os.WriteFile(jsFilePath, []byte(code), 0644); err != nil {
-		log.Printf("Error writing code to file: %v", err)
-		return structs.Verdict{}, err
-	}
-
-	output, err := exec.CommandContext(ctx, nodeBinary, "--check", jsFilePath).CombinedOutput()
-	if err != nil {
-		log.Printf("Node.js syntax error: %v, output: %s", err, string(output))
-		return structs.Verdict{
-			Submission: submission,
-			Result:     "ce",
-			MaxTime:    nil,
-			MaxRSS:     nil,
-		}, errors.New("compilation error")
-	}
-
-	return structs.Verdict{}, nil
+	return "/usr/bin/node"
 }
 
-func (p *NodeJS) Run(ctx context.Context, boxId int, submission *structs.Submission, handler *handlers.Handler) structs.Verdict {
-	boxPath := fmt.Sprintf("/var/local/lib/isolate/%d/box/", boxId)
-	nodeBinary, err := resolveNodeBinary()
-	if err != nil {
-		log.Printf("Node.js executable not found: %v", err)
-		result := "ie"
-		return structs.Verdict{
-			Submission: submission,
-			Result:     result,
-			MaxTime:    nil,
-			MaxRSS:     nil,
-		}
-	}
-
-	var maxTime float32
-	var maxRSS float32
-	finalResult := "ac"
-
-	inputPath := filepath.Join(boxPath, "in.txt")
-	expectedOutputPath := filepath.Join(boxPath, "expOut.txt")
-	outputPath := filepath.Join(boxPath, "out.txt")
-	metaPath := filepath.Join(boxPath, "meta.txt")
-
-	memLimit := submission.MemoryLimit * 1024
-
-	for _, test := range submission.Testcases {
-		input := test.Input
-		output := test.ExpectedOutput
-
-		if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
-			log.Printf("Error writing input file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		if err := os.WriteFile(expectedOutputPath, []byte(output), 0644); err != nil {
-			log.Printf("Error writing expected output file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		if err := os.WriteFile(outputPath, []byte(""), 0644); err != nil {
-			log.Printf("Error writing output file: %v", err)
-			finalResult = "ie"
-			break
-		}
-
-		isolateCmd := exec.CommandContext(ctx, "isolate",
-			fmt.Sprintf("--box-id=%d", boxId),
-			"--cg",
-			"--processes=16",
-			"--stdin=in.txt",
-			"--stdout=out.txt",
-			fmt.Sprintf("--time=%.3f", submission.TimeLimit),
-			fmt.Sprintf("--wall-time=%.3f", (submission.TimeLimit)*1.5),
-			"--fsize=10240",
-			fmt.Sprintf("--cg-mem=%d", int(memLimit)),
-			fmt.Sprintf("--meta=%s", metaPath),
-			"--run",
-			"--",
-			nodeBinary,
-			"main.js",
-		)
-
-		_ = isolateCmd.Run()
-
-		switch submission.CheckerType {
-		case "float":
-			handler.CompareFloat(boxPath, &maxTime, &maxRSS, &finalResult, submission.CheckerStrictSpace, submission.CheckerPrecision)
-		default:
-			handler.Compare(boxPath, &maxTime, &maxRSS, &finalResult, submission.CheckerStrictSpace)
-		}
-
-		if finalResult != "ac" {
-			break
-		}
-	}
-
-	return structs.Verdict{
-		Submission: submission,
-		Result:     finalResult,
-		MaxTime:    &maxTime,
-		MaxRSS:     &maxRSS,
+func nodeDefinition() config.Language {
+	nodeBinary := resolveNodeBinary()
+	return config.Language{
+		Name:       "js",
+		Aliases:    []string{"javascript", "node", "nodejs"},
+		SourceFile: "main.js",
+		Compile:    []string{nodeBinary, "--check", "main.js"},
+		Run:        []string{nodeBinary, "main.js"},
+		Processes:  16,
 	}
 }
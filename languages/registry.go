@@ -0,0 +1,117 @@
+package languages
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/judgenot0/judge-deamon/config"
+)
+
+// registry maps language names and aliases to their runner. It is replaced
+// wholesale by Load, so jobs holding a *Language keep their old definition.
+var registry atomic.Pointer[map[string]*Language]
+
+func init() {
+	if err := Load(nil); err != nil {
+		panic(err)
+	}
+}
+
+func builtinDefinitions() []config.Language {
+	return []config.Language{
+		cDefinition(),
+		cppDefinition(),
+		pythonDefinition(),
+		nodeDefinition(),
+	}
+}
+
+// Load merges overrides over the built-in definitions, validates the result
+// and swaps it in. On error the current definitions are kept.
+func Load(overrides []config.Language) error {
+	languages, err := build(overrides)
+	if err != nil {
+		return err
+	}
+	registry.Store(&languages)
+	return nil
+}
+
+// Check reports the error Load would return for overrides, without
+// swapping anything in.
+func Check(overrides []config.Language) error {
+	_, err := build(overrides)
+	return err
+}
+
+func build(overrides []config.Language) (map[string]*Language, error) {
+	definitions := builtinDefinitions()
+	for _, override := range overrides {
+		if override.Name == "" {
+			return nil, fmt.Errorf("language definition without a name")
+		}
+		merged := false
+		for i := range definitions {
+			if definitions[i].Name == override.Name {
+				definitions[i] = mergeDefinition(definitions[i], override)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			definitions = append(definitions, override)
+		}
+	}
+
+	languages := make(map[string]*Language)
+	for _, def := range definitions {
+		if def.SourceFile == "" {
+			return nil, fmt.Errorf("language %q: source_file is required", def.Name)
+		}
+		if len(def.Run) == 0 {
+			return nil, fmt.Errorf("language %q: run is required", def.Name)
+		}
+		if def.WallTimeMultiplier < 0 || (def.WallTimeMultiplier > 0 && def.WallTimeMultiplier < 1) {
+			return nil, fmt.Errorf("language %q: wall_time_multiplier must be at least 1", def.Name)
+		}
+		lang := &Language{def: def}
+		for _, name := range append([]string{def.Name}, def.Aliases...) {
+			if _, ok := languages[name]; ok {
+				return nil, fmt.Errorf("language name %q is defined twice", name)
+			}
+			languages[name] = lang
+		}
+	}
+
+	return languages, nil
+}
+
+func mergeDefinition(base, override config.Language) config.Language {
+	if override.Aliases != nil {
+		base.Aliases = override.Aliases
+	}
+	if override.SourceFile != "" {
+		base.SourceFile = override.SourceFile
+	}
+	if override.Compile != nil {
+		base.Compile = override.Compile
+	}
+	if override.Binary != "" {
+		base.Binary = override.Binary
+	}
+	if override.Run != nil {
+		base.Run = override.Run
+	}
+	if override.Processes != 0 {
+		base.Processes = override.Processes
+	}
+	if override.WallTimeMultiplier != 0 {
+		base.WallTimeMultiplier = override.WallTimeMultiplier
+	}
+	return base
+}
+
+// Lookup returns the language registered under name or alias, or nil.
+func Lookup(name string) *Language {
+	return (*registry.Load())[name]
+}
@@ -7,28 +7,61 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
-	"time"
 
+	"github.com/judgenot0/judge-deamon/artifacts"
+	"github.com/judgenot0/judge-deamon/broker"
 	"github.com/judgenot0/judge-deamon/cmd"
 	"github.com/judgenot0/judge-deamon/config"
 	"github.com/judgenot0/judge-deamon/handlers"
+	"github.com/judgenot0/judge-deamon/languages"
 	"github.com/judgenot0/judge-deamon/queue"
 	"github.com/judgenot0/judge-deamon/scheduler"
+	"github.com/judgenot0/judge-deamon/testcases"
 )
 
+func newBroker(config *config.Config) (broker.Broker, error) {
+	retry := broker.RetryPolicy{
+		MaxRetries: config.MaxRetries,
+		BaseDelay:  config.RetryBaseDelay,
+		MaxDelay:   config.RetryMaxDelay,
+	}
+	if config.Broker == "memory" {
+		return broker.NewMemory(config.BrokerFile, retry)
+	}
+
+	queueManager := queue.NewQueue()
+	if err := queueManager.InitQueue(config, retry); err != nil {
+		return nil, err
+	}
+	return queueManager, nil
+}
+
 func main() {
 	config := config.GetConfig()
 
 	notifierCtx, notifierCancel := context.WithCancel(context.Background())
 	defer notifierCancel()
 
-	queueManager := queue.NewQueue()
-	err := queueManager.InitQueue(config)
+	queueManager, err := newBroker(config)
 	if err != nil {
 		log.Fatalf("Failed to initialize queue: %v", err)
 	}
 
+	if err := languages.Load(config.Languages); err != nil {
+		log.Fatalf("Invalid language definitions: %v", err)
+	}
+
 	handler := handlers.NewHandler(config)
+	handler.Testcases, err = testcases.New(config.TestcaseCacheDir, config.TestcaseCacheMB)
+	if err != nil {
+		log.Fatalf("Failed to open testcase cache: %v", err)
+	}
+	if config.ArtifactCacheMB > 0 {
+		handler.Artifacts, err = artifacts.New(config.ArtifactCacheDir, config.ArtifactCacheMB)
+		if err != nil {
+			log.Fatalf("Failed to open artifact cache: %v", err)
+		}
+	}
 
 	scheduler := scheduler.NewScheduler(handler)
 	if err := scheduler.With(config.WorkerCount); err != nil {
@@ -47,7 +80,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		log.Println("[*] Waiting for messages. To exit press CTRL+C")
-		if err := queueManager.StartConsume(notifierCtx, scheduler); err != nil {
+		if err := queueManager.Consume(notifierCtx, scheduler.Dispatch); err != nil {
 			log.Printf("Queue consumer stopped: %v", err)
 		}
 	}()
@@ -61,11 +94,22 @@ func main() {
 		}
 	}()
 
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			log.Println("[*] SIGHUP received, reloading configuration")
+			if err := server.Reload(); err != nil {
+				log.Printf("Reload failed: %v", err)
+			}
+		}
+	}()
+
 	<-sigChan
 	log.Println("\n[*] Shutting down gracefully...")
 	notifierCancel()
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
 	defer shutdownCancel()
 
 	shutdownDone := make(chan struct{})
@@ -85,6 +129,10 @@ func main() {
 
 	wg.Wait()
 
+	if err := scheduler.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down workers: %v", err)
+	}
+
 	if err := queueManager.Close(); err != nil {
 		log.Printf("Error closing queue: %v", err)
 	}
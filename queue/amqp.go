@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/judgenot0/judge-deamon/broker"
 	"github.com/judgenot0/judge-deamon/config"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -14,23 +15,45 @@ type Queue struct {
 	ch          *amqp.Channel
 	queueName   string
 	rabbitmqURL string
-	workerCount int
+	prefetch    int
 	ctx         context.Context
 	mu          sync.RWMutex
+
+	retry     broker.RetryPolicy
+	retryOnce sync.Once
 }
 
+var _ broker.Broker = (*Queue)(nil)
+
 func NewQueue() *Queue {
-	return &Queue{}
+	return &Queue{
+		retry: broker.DefaultRetryPolicy(),
+	}
 }
 
-func (q *Queue) InitQueue(config *config.Config) error {
+func (q *Queue) InitQueue(config *config.Config, retry broker.RetryPolicy) error {
+	q.retry = retry
 	q.queueName = config.QueueName
 	q.rabbitmqURL = config.RabbitMQURL
-	q.workerCount = config.WorkerCount
+	q.prefetch = config.Prefetch()
 
 	return q.connect()
 }
 
+// SetPrefetch updates the QoS of the current channel and of channels opened
+// on reconnect.
+func (q *Queue) SetPrefetch(count int) error {
+	q.mu.Lock()
+	q.prefetch = count
+	ch := q.ch
+	q.mu.Unlock()
+
+	if ch == nil || ch.IsClosed() {
+		return nil
+	}
+	return ch.Qos(count, 0, false)
+}
+
 func (q *Queue) getChannel() (*amqp.Channel, *amqp.Connection) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
@@ -22,7 +22,11 @@ func (q *Queue) connect() error {
 		return err
 	}
 
-	err = ch.Qos(q.workerCount, 0, false)
+	q.mu.RLock()
+	prefetch := q.prefetch
+	q.mu.RUnlock()
+
+	err = ch.Qos(prefetch, 0, false)
 	if err != nil {
 		log.Printf("Failed to set QoS: %v", err)
 		ch.Close()
@@ -56,6 +60,32 @@ func (q *Queue) connect() error {
 		return err
 	}
 
+	parkingName := q.parkingQueueName()
+	_, err = ch.QueueDeclare(parkingName, true, false, false, false, amqp.Table{"x-queue-type": "quorum"})
+	if err != nil {
+		log.Printf("Failed to declare parking queue: %v", err)
+		ch.Close()
+		conn.Close()
+		return err
+	}
+
+	// Each attempt waits in its own delay queue; expired messages are
+	// dead-lettered through the default exchange back into the main queue.
+	for attempt := 1; attempt <= q.retry.MaxRetries; attempt++ {
+		retryArgs := amqp.Table{
+			"x-queue-type":              "quorum",
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": q.queueName,
+		}
+		_, err = ch.QueueDeclare(q.retryQueueName(attempt), true, false, false, false, retryArgs)
+		if err != nil {
+			log.Printf("Failed to declare retry queue for attempt %d: %v", attempt, err)
+			ch.Close()
+			conn.Close()
+			return err
+		}
+	}
+
 	args := amqp.Table{
 		"x-queue-type":              "quorum",
 		"x-dead-letter-exchange":    dlxName,
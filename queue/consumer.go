@@ -2,93 +2,48 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"time"
 
-	"github.com/judgenot0/judge-deamon/scheduler"
-	"github.com/judgenot0/judge-deamon/structs"
+	"github.com/judgenot0/judge-deamon/broker"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func (q *Queue) StartDLQProcessor(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	dlqName := q.queueName + "_dlq"
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping DLQ processor")
-			return
-		case <-ticker.C:
-			ch, _ := q.getChannel()
-			if ch == nil || ch.IsClosed() {
-				continue
-			}
-
-			for {
-				msg, ok, err := ch.Get(dlqName, false)
-				if err != nil {
-					log.Printf("Error fetching from DLQ: %v", err)
-					break
-				}
-				if !ok {
-					break
-				}
+type delivery struct {
+	queue *Queue
+	ch    *amqp.Channel
+	d     amqp.Delivery
+}
 
-				var retryCount int32
-				if msg.Headers != nil {
-					if count, ok := msg.Headers["x-retry-count"].(int32); ok {
-						retryCount = count
-					}
-				}
+func (d *delivery) Body() []byte {
+	return d.d.Body
+}
 
-				if retryCount >= 5 {
-					bodyLimit := 100
-					if len(msg.Body) < bodyLimit {
-						bodyLimit = len(msg.Body)
-					}
-					log.Printf("Message exceeded max retries (5). Dropping permanently. Body snippet: %s", string(msg.Body[:bodyLimit]))
-					msg.Ack(false)
-					continue
-				}
+// EnqueuedAt is the publish timestamp, which AMQP keeps in whole seconds: a
+// rejudge published in the same second as a cancel is still skipped.
+func (d *delivery) EnqueuedAt() time.Time {
+	return d.d.Timestamp
+}
 
-				headers := msg.Headers
-				if headers == nil {
-					headers = make(amqp.Table)
-				}
-				headers["x-retry-count"] = retryCount + 1
+func (d *delivery) Ack() error {
+	return d.d.Ack(false)
+}
 
-				err = ch.Publish(
-					"",
-					q.queueName,
-					false,
-					false,
-					amqp.Publishing{
-						Headers:      headers,
-						ContentType:  msg.ContentType,
-						Body:         msg.Body,
-						DeliveryMode: msg.DeliveryMode,
-					},
-				)
+func (d *delivery) Nack(reason string) error {
+	d.queue.deadLetter(d.ch, d.d, reason)
+	return nil
+}
 
-				if err != nil {
-					log.Printf("Error requeuing message from DLQ: %v", err)
-					msg.Nack(false, true)
-					break
-				} else {
-					log.Printf("Successfully requeued a message from DLQ")
-					msg.Ack(false)
-				}
-			}
-		}
-	}
+func (d *delivery) Reject(reason string) error {
+	return d.queue.park(d.ch, d.d, reason)
 }
 
-func (q *Queue) StartConsume(ctx context.Context, scheduler *scheduler.Scheduler) error {
+func (q *Queue) Consume(ctx context.Context, handle broker.Handler) error {
 	q.ctx = ctx
+	q.retryOnce.Do(func() {
+		go q.runRetryProcessor(ctx)
+	})
+
 	for {
 		ch, conn := q.getChannel()
 		if ch == nil || ch.IsClosed() || conn == nil || conn.IsClosed() {
@@ -113,7 +68,6 @@ func (q *Queue) StartConsume(ctx context.Context, scheduler *scheduler.Scheduler
 		}
 
 		log.Println("[*] Started consuming messages from queue")
-		go q.StartDLQProcessor(ctx)
 
 	messageLoop:
 		for {
@@ -126,32 +80,7 @@ func (q *Queue) StartConsume(ctx context.Context, scheduler *scheduler.Scheduler
 					log.Println("Message channel closed")
 					break messageLoop
 				}
-
-				select {
-				case <-ctx.Done():
-					log.Println("Context cancelled, nacking message to DLQ and stopping")
-					d.Nack(false, false)
-					return nil
-
-				case worker := <-scheduler.WorkChannel:
-					var submission structs.Submission
-					err := json.Unmarshal(d.Body, &submission)
-					if err != nil {
-						log.Printf("Raw body: %s", string(d.Body))
-						log.Printf("Invalid message body: %v", err)
-						d.Nack(false, false)
-						scheduler.WorkChannel <- worker
-						continue
-					}
-
-					go func(delivery amqp.Delivery, w structs.Worker, sub *structs.Submission) {
-						scheduler.Work(ctx, w, sub, delivery)
-					}(d, worker, &submission)
-
-				case <-time.After(5 * time.Minute):
-					log.Println("Warning: No workers available for 5 minutes, message sent to DLQ")
-					d.Nack(false, false)
-				}
+				handle(ctx, &delivery{queue: q, ch: ch, d: d})
 			}
 		}
 
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deadLetterQueues returns the queues holding a source's messages. The DLQ
+// is drained into the retry queues every few seconds, so failed messages
+// are mostly found under retry.
+func (q *Queue) deadLetterQueues(source string) ([]string, error) {
+	switch source {
+	case "", broker.DeadLetterSourceDLQ:
+		return []string{q.dlqName()}, nil
+	case broker.DeadLetterSourceRetry:
+		var names []string
+		for attempt := 1; attempt <= q.retry.MaxRetries; attempt++ {
+			names = append(names, q.retryQueueName(attempt))
+		}
+		return names, nil
+	case broker.DeadLetterSourceParking:
+		return []string{q.parkingQueueName()}, nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter source %q", source)
+	}
+}
+
+// ListDeadLetters returns up to limit messages from the DLQ, the retry
+// queues or the parking queue without removing them.
+func (q *Queue) ListDeadLetters(source string, limit int) ([]broker.DeadLetter, error) {
+	queueNames, err := q.deadLetterQueues(source)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []broker.DeadLetter{}
+	err = q.scanDeadLetters(queueNames, func(ch *amqp.Channel, queueName string, msg amqp.Delivery) (bool, error) {
+		letters = append(letters, describeDeadLetter(msg, queueName == q.dlqName()))
+		return false, nil
+	}, func() bool {
+		return len(letters) >= limit
+	})
+	return letters, err
+}
+
+// ReplayDeadLetters moves matching messages back into the main queue with a
+// fresh retry budget and returns how many were replayed.
+func (q *Queue) ReplayDeadLetters(source string, filter broker.DeadLetterFilter) (int, error) {
+	queueNames, err := q.deadLetterQueues(source)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	err = q.scanDeadLetters(queueNames, func(ch *amqp.Channel, queueName string, msg amqp.Delivery) (bool, error) {
+		if id, _ := broker.MessageInfo(msg.Body); !filter.Matches(id) {
+			return false, nil
+		}
+
+		headers := copyHeaders(msg.Headers)
+		delete(headers, headerRetryCount)
+		delete(headers, headerRejectReason)
+		headers["x-replayed-at"] = time.Now().UTC().Format(time.RFC3339)
+
+		// Expiration is left out: a message replayed from a retry queue
+		// runs now rather than after its delay.
+		err := ch.Publish("", q.queueName, false, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			Body:         msg.Body,
+			DeliveryMode: msg.DeliveryMode,
+			Timestamp:    msg.Timestamp,
+		})
+		if err != nil {
+			return false, err
+		}
+		replayed++
+		return true, nil
+	}, func() bool {
+		return filter.Limit > 0 && replayed >= filter.Limit
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters permanently deletes matching messages and returns how
+// many were removed.
+func (q *Queue) PurgeDeadLetters(source string, filter broker.DeadLetterFilter) (int, error) {
+	queueNames, err := q.deadLetterQueues(source)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	err = q.scanDeadLetters(queueNames, func(ch *amqp.Channel, queueName string, msg amqp.Delivery) (bool, error) {
+		if id, _ := broker.MessageInfo(msg.Body); !filter.Matches(id) {
+			return false, nil
+		}
+		purged++
+		return true, nil
+	}, func() bool {
+		return filter.Limit > 0 && purged >= filter.Limit
+	})
+	return purged, err
+}
+
+// scanDeadLetters fetches the messages of queueNames in turn on a dedicated
+// channel and calls visit for each, until the queues are empty or done
+// reports true. Messages for which visit returns true are acked; all others
+// stay unacked until the scan finishes and are then requeued together, so a
+// message is visited at most once per scan. Only delivery tags are kept, not
+// bodies. Requeuing counts towards a quorum queue's delivery limit, which
+// the README says how to lift for these queues.
+func (q *Queue) scanDeadLetters(queueNames []string, visit func(ch *amqp.Channel, queueName string, msg amqp.Delivery) (bool, error), done func() bool) error {
+	_, conn := q.getChannel()
+	if conn == nil || conn.IsClosed() {
+		return fmt.Errorf("not connected to RabbitMQ")
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// The highest tag of a message left unacked; nacking it with multiple
+	// set requeues every kept message at once.
+	var lastKept uint64
+	defer func() {
+		if lastKept == 0 {
+			return
+		}
+		if err := ch.Nack(lastKept, true, true); err != nil {
+			log.Printf("Error requeuing dead letters: %v", err)
+		}
+	}()
+
+	for _, queueName := range queueNames {
+		for !done() {
+			msg, ok, err := ch.Get(queueName, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+
+			remove, err := visit(ch, queueName, msg)
+			if err != nil {
+				lastKept = msg.DeliveryTag
+				return err
+			}
+			if !remove {
+				lastKept = msg.DeliveryTag
+				continue
+			}
+			if err := msg.Ack(false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// describeDeadLetter summarizes a message. Messages still in the DLQ have
+// not been through the retry processor yet, so their latest failure is read
+// from the reject reason or x-death; the processor moves it to
+// x-failure-reason for the retry and parking queues.
+func describeDeadLetter(msg amqp.Delivery, inDLQ bool) broker.DeadLetter {
+	id, language := broker.MessageInfo(msg.Body)
+	letter := broker.DeadLetter{
+		SubmissionId:      id,
+		Language:          language,
+		RetryCount:        retryCount(msg.Headers),
+		LastFailureReason: failureReason(msg.Headers),
+	}
+	if reason, ok := msg.Headers[headerFailureReason].(string); ok && reason != "" && !inDLQ {
+		letter.LastFailureReason = reason
+	}
+	if firstFailedAt, ok := msg.Headers[headerFirstFailedAt].(string); ok {
+		letter.FirstFailedAt = firstFailedAt
+	}
+
+	since := msg.Timestamp
+	if since.IsZero() {
+		if t, err := time.Parse(time.RFC3339, letter.FirstFailedAt); err == nil {
+			since = t
+		}
+	}
+	if !since.IsZero() {
+		letter.AgeSeconds = time.Since(since).Seconds()
+	}
+	return letter
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    string
+	}{
+		{"reject reason", amqp.Table{headerRejectReason: "boom", "x-death": []interface{}{amqp.Table{"reason": "rejected", "queue": "judge_queue"}}}, "boom"},
+		{"x-death", amqp.Table{"x-death": []interface{}{amqp.Table{"reason": "expired", "queue": "judge_queue_retry_1"}}}, "expired from judge_queue_retry_1"},
+		{"empty reject reason", amqp.Table{headerRejectReason: ""}, "unknown"},
+		{"nothing", nil, "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := failureReason(tt.headers); got != tt.want {
+			t.Errorf("%s: failureReason = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeDeadLetter(t *testing.T) {
+	firstFailed := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	// As left by retryOrPark: the reject reason moved to x-failure-reason,
+	// with a stale x-death from an earlier trip through the DLQ.
+	retried := amqp.Delivery{
+		Body: []byte(`{"submission_id":42,"language":"cpp"}`),
+		Headers: amqp.Table{
+			headerRetryCount:    int32(2),
+			headerFailureReason: "compile server down",
+			headerFirstFailedAt: firstFailed,
+			"x-death":           []interface{}{amqp.Table{"reason": "rejected", "queue": "judge_queue"}},
+		},
+	}
+
+	letter := describeDeadLetter(retried, false)
+	if letter.SubmissionId == nil || *letter.SubmissionId != 42 || letter.Language != "cpp" || letter.RetryCount != 2 {
+		t.Errorf("letter = %+v", letter)
+	}
+	if letter.LastFailureReason != "compile server down" {
+		t.Errorf("retried letter reason = %q, want x-failure-reason", letter.LastFailureReason)
+	}
+	if letter.FirstFailedAt != firstFailed || letter.AgeSeconds < 59 {
+		t.Errorf("first failed at %q, age %v", letter.FirstFailedAt, letter.AgeSeconds)
+	}
+
+	// In the DLQ the new reject reason is the latest failure.
+	rejected := amqp.Delivery{Headers: amqp.Table{headerRejectReason: "timeout", headerFailureReason: "compile server down"}}
+	if reason := describeDeadLetter(rejected, true).LastFailureReason; reason != "timeout" {
+		t.Errorf("DLQ letter reason = %q, want the reject reason", reason)
+	}
+}
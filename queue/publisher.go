@@ -1,12 +1,14 @@
 package queue
 
 import (
+	"context"
 	"log"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func (q *Queue) QueueMessage(submission []byte) error {
+func (q *Queue) Publish(ctx context.Context, submission []byte) error {
 	ch, _ := q.getChannel()
 	if ch == nil || ch.IsClosed() {
 		if err := q.reconnect(); err != nil {
@@ -23,6 +25,7 @@ func (q *Queue) QueueMessage(submission []byte) error {
 		amqp.Publishing{
 			ContentType: "application/json",
 			Body:        submission,
+			Timestamp:   time.Now(),
 		},
 	)
 
@@ -40,6 +43,7 @@ func (q *Queue) QueueMessage(submission []byte) error {
 			amqp.Publishing{
 				ContentType: "application/json",
 				Body:        submission,
+				Timestamp:   time.Now(),
 			},
 		)
 	}
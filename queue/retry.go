@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	dlqPollInterval = 5 * time.Second
+
+	headerRetryCount    = "x-retry-count"
+	headerRejectReason  = "x-reject-reason"
+	headerFailureReason = "x-failure-reason"
+	headerFirstFailedAt = "x-first-failed-at"
+	headerLastFailedAt  = "x-last-failed-at"
+)
+
+func (q *Queue) dlqName() string {
+	return q.queueName + "_dlq"
+}
+
+func (q *Queue) parkingQueueName() string {
+	return q.queueName + "_parking"
+}
+
+func (q *Queue) retryQueueName(attempt int) string {
+	return fmt.Sprintf("%s_retry_%d", q.queueName, attempt)
+}
+
+// deadLetter routes a delivery to the DLQ with a reason attached. The reason
+// cannot be added through Nack, so the message is republished to the DLX and
+// the original acknowledged; if that fails we fall back to a plain Nack.
+func (q *Queue) deadLetter(ch *amqp.Channel, d amqp.Delivery, reason string) {
+	headers := copyHeaders(d.Headers)
+	headers[headerRejectReason] = reason
+
+	err := ch.Publish(
+		q.queueName+"_dlx",
+		q.queueName,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			Body:         d.Body,
+			DeliveryMode: d.DeliveryMode,
+			Timestamp:    d.Timestamp,
+		},
+	)
+	if err != nil {
+		log.Printf("Error dead-lettering message (%s), falling back to nack: %v", reason, err)
+		if err := d.Nack(false, false); err != nil {
+			log.Printf("Error nacking message: %v", err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Error acknowledging dead-lettered message: %v", err)
+	}
+}
+
+// park moves a delivery straight to the parking queue, skipping retries.
+func (q *Queue) park(ch *amqp.Channel, d amqp.Delivery, reason string) error {
+	headers := copyHeaders(d.Headers)
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, ok := headers[headerFirstFailedAt]; !ok {
+		headers[headerFirstFailedAt] = now
+	}
+	headers[headerLastFailedAt] = now
+	headers[headerFailureReason] = reason
+
+	err := ch.Publish("", q.parkingQueueName(), false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		Body:         d.Body,
+		DeliveryMode: d.DeliveryMode,
+		Timestamp:    d.Timestamp,
+	})
+	if err != nil {
+		if nackErr := d.Nack(false, false); nackErr != nil {
+			log.Printf("Error nacking message: %v", nackErr)
+		}
+		return err
+	}
+	log.Printf("Message rejected and parked in %s: %s", q.parkingQueueName(), reason)
+	return d.Ack(false)
+}
+
+// runRetryProcessor drains the DLQ, moving each message into the delay queue
+// for its next attempt, or into the parking queue once retries are exhausted.
+// It must run once per Queue; StartConsume guards it with retryOnce.
+func (q *Queue) runRetryProcessor(ctx context.Context) {
+	ticker := time.NewTicker(dlqPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping DLQ processor")
+			return
+		case <-ticker.C:
+			ch, _ := q.getChannel()
+			if ch == nil || ch.IsClosed() {
+				continue
+			}
+
+			for {
+				msg, ok, err := ch.Get(q.dlqName(), false)
+				if err != nil {
+					log.Printf("Error fetching from DLQ: %v", err)
+					break
+				}
+				if !ok {
+					break
+				}
+
+				if err := q.retryOrPark(ch, msg); err != nil {
+					log.Printf("Error moving message out of DLQ: %v", err)
+					msg.Nack(false, true)
+					break
+				}
+				msg.Ack(false)
+			}
+		}
+	}
+}
+
+func (q *Queue) retryOrPark(ch *amqp.Channel, msg amqp.Delivery) error {
+	headers := copyHeaders(msg.Headers)
+	reason := failureReason(headers)
+	delete(headers, headerRejectReason)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, ok := headers[headerFirstFailedAt]; !ok {
+		headers[headerFirstFailedAt] = now
+	}
+	headers[headerLastFailedAt] = now
+	headers[headerFailureReason] = reason
+
+	attempt := int(retryCount(headers)) + 1
+	publishing := amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		Body:         msg.Body,
+		DeliveryMode: msg.DeliveryMode,
+		Timestamp:    msg.Timestamp,
+	}
+
+	if attempt > q.retry.MaxRetries {
+		if err := ch.Publish("", q.parkingQueueName(), false, false, publishing); err != nil {
+			return err
+		}
+		log.Printf("Message exceeded max retries (%d), parked in %s. Last failure: %s", q.retry.MaxRetries, q.parkingQueueName(), reason)
+		return nil
+	}
+
+	headers[headerRetryCount] = int32(attempt)
+	delay := q.retry.Delay(attempt)
+	publishing.Expiration = strconv.FormatInt(delay.Milliseconds(), 10)
+	if err := ch.Publish("", q.retryQueueName(attempt), false, false, publishing); err != nil {
+		return err
+	}
+	log.Printf("Scheduled retry %d/%d in %v. Failure: %s", attempt, q.retry.MaxRetries, delay, reason)
+	return nil
+}
+
+func copyHeaders(src amqp.Table) amqp.Table {
+	headers := make(amqp.Table, len(src)+1)
+	for k, v := range src {
+		headers[k] = v
+	}
+	return headers
+}
+
+func retryCount(headers amqp.Table) int32 {
+	switch v := headers[headerRetryCount].(type) {
+	case int32:
+		return v
+	case int64:
+		return int32(v)
+	case int:
+		return int32(v)
+	}
+	return 0
+}
+
+// failureReason prefers the reason attached by deadLetter and otherwise
+// falls back to the most recent x-death entry added by RabbitMQ.
+func failureReason(headers amqp.Table) string {
+	if reason, ok := headers[headerRejectReason].(string); ok && reason != "" {
+		return reason
+	}
+	if deaths, ok := headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			reason, _ := death["reason"].(string)
+			queue, _ := death["queue"].(string)
+			if reason != "" {
+				return fmt.Sprintf("%s from %s", reason, queue)
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,143 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrNotRegular is returned for a box file that is a symlink, directory or
+// anything else but a regular file.
+var ErrNotRegular = errors.New("not a regular file")
+
+// boxFile resolves name inside the box directory, refusing paths that
+// would escape it.
+func boxFile(boxPath, name string) (string, error) {
+	path := filepath.Join(boxPath, name)
+	if !strings.HasPrefix(path, filepath.Clean(boxPath)+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %q is outside the box", name)
+	}
+	return path, nil
+}
+
+func putFile(boxPath, name string, data []byte) error {
+	path, err := boxFile(boxPath, name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func getFile(boxPath, name string) ([]byte, error) {
+	path, err := boxFile(boxPath, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := OpenOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// OpenOutput opens a file the program left in its box, such as its output.
+// The program may have replaced it with a symlink to a host file, or with a
+// FIFO that would block the engine, so only regular files are opened;
+// anything else is ErrNotRegular.
+func OpenOutput(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRegular)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRegular)
+	}
+	return file, nil
+}
+
+// PrivateDir creates dir, if need be, for files only the engine may change,
+// such as a cache. A directory the engine's user owns is made 0700; one
+// owned by another user, who could have planted files in it, is refused,
+// as is a symlink.
+func PrivateDir(dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not by the engine's user", dir, stat.Uid)
+	}
+	if info.Mode().Perm() != 0700 {
+		return os.Chmod(dir, 0700)
+	}
+	return nil
+}
+
+// CopyDir copies the files in src into dst, keeping their modes, e.g. to
+// put a compiled program into a box.
+func CopyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot copy %s: not a regular file", rel)
+		}
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
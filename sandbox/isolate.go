@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// Isolate runs programs with IOI isolate using its cgroup mode.
+type Isolate struct {
+	root string
+}
+
+func NewIsolate(root string) *Isolate {
+	return &Isolate{root: root}
+}
+
+func (s *Isolate) Init(boxId int) error {
+	return exec.Command("isolate", fmt.Sprintf("--box-id=%d", boxId), "--cg", "--init").Run()
+}
+
+func (s *Isolate) Cleanup(boxId int) error {
+	return exec.Command("isolate", fmt.Sprintf("--box-id=%d", boxId), "--cg", "--cleanup").Run()
+}
+
+func (s *Isolate) BoxPath(boxId int) string {
+	return fmt.Sprintf("%s/%d/box/", s.root, boxId)
+}
+
+func (s *Isolate) PutFile(boxId int, name string, data []byte) error {
+	return putFile(s.BoxPath(boxId), name, data)
+}
+
+func (s *Isolate) GetFile(boxId int, name string) ([]byte, error) {
+	return getFile(s.BoxPath(boxId), name)
+}
+
+func (s *Isolate) Run(ctx context.Context, boxId int, spec RunSpec) (*Result, error) {
+	// The meta file is kept outside the box so the program cannot touch it.
+	metaFile, err := os.CreateTemp("", "isolate-meta-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating meta file: %w", err)
+	}
+	metaFile.Close()
+	defer os.Remove(metaFile.Name())
+
+	args := []string{
+		fmt.Sprintf("--box-id=%d", boxId),
+		"--cg",
+	}
+	if spec.Processes > 0 {
+		args = append(args, fmt.Sprintf("--processes=%d", spec.Processes))
+	}
+	if spec.InputDir != "" {
+		// Directory rules are read-only unless marked otherwise.
+		args = append(args, fmt.Sprintf("--dir=%s=%s", InputMount, spec.InputDir))
+	}
+	if spec.Stdin != "" {
+		stdin := spec.Stdin
+		if spec.InputDir != "" {
+			stdin = path.Join(InputMount, stdin)
+		}
+		args = append(args, "--stdin="+stdin)
+	}
+	if spec.Stdout != "" {
+		args = append(args, "--stdout="+spec.Stdout)
+	}
+	if spec.Stderr != "" {
+		args = append(args, "--stderr="+spec.Stderr)
+	}
+	args = append(args,
+		fmt.Sprintf("--time=%.3f", spec.Time),
+		fmt.Sprintf("--wall-time=%.3f", spec.WallTime),
+		fmt.Sprintf("--fsize=%d", spec.FsizeKB),
+		fmt.Sprintf("--cg-mem=%d", spec.MemoryKB),
+		fmt.Sprintf("--meta=%s", metaFile.Name()),
+		"--run",
+		"--",
+	)
+	args = append(args, spec.Argv...)
+
+	// isolate exits non-zero whenever the program fails; the meta file is
+	// what tells the cases apart.
+	runErr := exec.CommandContext(ctx, "isolate", args...).Run()
+
+	data, err := os.ReadFile(metaFile.Name())
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("isolate produced no meta file: %v (run: %v)", err, runErr)
+	}
+	return ParseMeta(data), nil
+}
@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseMeta reads isolate's key:value meta file format. Unknown keys and
+// malformed lines are ignored.
+func ParseMeta(data []byte) *Result {
+	var meta Result
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "status":
+			meta.Status = value
+		case "message":
+			meta.Message = value
+		case "killed":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.Killed = v
+			}
+		case "exitcode":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.ExitCode = v
+			}
+		case "exitsig":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.ExitSig = v
+			}
+		case "time":
+			if v, err := strconv.ParseFloat(value, 32); err == nil {
+				meta.Time = float32(v)
+			}
+		case "time-wall":
+			if v, err := strconv.ParseFloat(value, 32); err == nil {
+				meta.Time_Wall = float32(v)
+			}
+		case "max-rss":
+			if v, err := strconv.ParseFloat(value, 32); err == nil {
+				meta.Max_RSS = float32(v)
+			}
+		case "cg-mem":
+			if v, err := strconv.ParseFloat(value, 32); err == nil {
+				meta.CG_Mem = float32(v)
+			}
+		case "cg-oom-killed":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.CG_OOM_Killed = v
+			}
+		case "csw-voluntary":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.CSW_Voluntary = v
+			}
+		case "csw-forced":
+			if v, err := strconv.Atoi(value); err == nil {
+				meta.CSW_Forced = v
+			}
+		}
+	}
+	return &meta
+}
@@ -0,0 +1,44 @@
+package sandbox
+
+import "testing"
+
+func TestParseMeta(t *testing.T) {
+	meta := ParseMeta([]byte(`time:0.512
+time-wall:0.730
+max-rss:20480
+csw-voluntary:3
+csw-forced:12
+cg-mem:19000
+cg-oom-killed:1
+exitsig:9
+killed:1
+status:SG
+message:Caught fatal signal 9
+garbage line
+exitcode:notanumber
+`))
+
+	want := Result{
+		Status:        "SG",
+		Message:       "Caught fatal signal 9",
+		Killed:        1,
+		Time:          0.512,
+		Time_Wall:     0.730,
+		Max_RSS:       20480,
+		CG_Mem:        19000,
+		CG_OOM_Killed: 1,
+		ExitSig:       9,
+		CSW_Voluntary: 3,
+		CSW_Forced:    12,
+	}
+	if *meta != want {
+		t.Errorf("ParseMeta() = %+v, want %+v", *meta, want)
+	}
+}
+
+func TestParseMetaMessageWithColon(t *testing.T) {
+	meta := ParseMeta([]byte("status:XX\nmessage:Cannot run proxy: No such file\n"))
+	if meta.Message != "Cannot run proxy: No such file" {
+		t.Errorf("Message = %q", meta.Message)
+	}
+}
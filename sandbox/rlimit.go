@@ -0,0 +1,144 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Rlimit runs programs as the engine's own user, limited only by setrlimit
+// (applied by util-linux prlimit, which then execs the program) and a
+// wall-clock timer. It needs no privileges or cgroups and is meant for
+// development machines; it does not isolate the filesystem or network.
+// Memory is capped as address space, so runtimes that reserve large virtual
+// ranges (Node.js, the JVM) may need a higher memory limit than under
+// isolate.
+type Rlimit struct {
+	root string
+}
+
+func NewRlimit(root string) *Rlimit {
+	return &Rlimit{root: root}
+}
+
+func (s *Rlimit) Init(boxId int) error {
+	return os.MkdirAll(s.BoxPath(boxId), 0o755)
+}
+
+func (s *Rlimit) Cleanup(boxId int) error {
+	return os.RemoveAll(filepath.Join(s.root, fmt.Sprint(boxId)))
+}
+
+func (s *Rlimit) BoxPath(boxId int) string {
+	return fmt.Sprintf("%s/%d/box/", s.root, boxId)
+}
+
+func (s *Rlimit) PutFile(boxId int, name string, data []byte) error {
+	return putFile(s.BoxPath(boxId), name, data)
+}
+
+func (s *Rlimit) GetFile(boxId int, name string) ([]byte, error) {
+	return getFile(s.BoxPath(boxId), name)
+}
+
+func (s *Rlimit) Run(ctx context.Context, boxId int, spec RunSpec) (*Result, error) {
+	boxPath := s.BoxPath(boxId)
+
+	wallCtx, cancel := context.WithTimeout(ctx, time.Duration(float64(spec.WallTime)*float64(time.Second)))
+	defer cancel()
+
+	cpuSeconds := int(math.Ceil(float64(spec.Time)))
+	args := []string{
+		// SIGXCPU at the soft limit, SIGKILL a second later if ignored
+		fmt.Sprintf("--cpu=%d:%d", max(cpuSeconds, 1), max(cpuSeconds, 1)+1),
+		fmt.Sprintf("--as=%d", spec.MemoryKB*1024),
+		fmt.Sprintf("--fsize=%d", spec.FsizeKB*1024),
+		"--",
+	}
+	args = append(args, spec.Argv...)
+
+	cmd := exec.CommandContext(wallCtx, "prlimit", args...)
+	cmd.Dir = boxPath
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + boxPath}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// Kill the whole process group, not just the direct child.
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	if spec.Stdin != "" {
+		// Nothing is mounted: the program reads InputDir where it is.
+		stdinDir := boxPath
+		if spec.InputDir != "" {
+			stdinDir = spec.InputDir
+		}
+		stdin, err := os.Open(filepath.Join(stdinDir, spec.Stdin))
+		if err != nil {
+			return nil, fmt.Errorf("opening stdin: %w", err)
+		}
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
+	if spec.Stdout != "" {
+		stdout, err := os.Create(filepath.Join(boxPath, spec.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("opening stdout: %w", err)
+		}
+		defer stdout.Close()
+		cmd.Stdout = stdout
+	}
+	if spec.Stderr != "" {
+		stderr, err := os.Create(filepath.Join(boxPath, spec.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("opening stderr: %w", err)
+		}
+		defer stderr.Close()
+		cmd.Stderr = stderr
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	wall := time.Since(start)
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("starting program: %w", err)
+	}
+
+	result := &Result{Time_Wall: float32(wall.Seconds())}
+	state := cmd.ProcessState
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+		result.Time = float32(cpu.Seconds())
+		result.Max_RSS = float32(usage.Maxrss) // kilobytes on Linux
+		result.CSW_Voluntary = int(usage.Nvcsw)
+		result.CSW_Forced = int(usage.Nivcsw)
+	}
+
+	status, _ := state.Sys().(syscall.WaitStatus)
+	switch {
+	case wallCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil:
+		result.Status = "TO"
+		result.Killed = 1
+		result.Message = "Time limit exceeded (wall clock)"
+	case status.Signaled() && status.Signal() == syscall.SIGXCPU, result.Time > spec.Time:
+		result.Status = "TO"
+		result.Killed = 1
+		result.Message = "Time limit exceeded"
+	case status.Signaled():
+		result.Status = "SG"
+		result.ExitSig = int(status.Signal())
+		result.Message = fmt.Sprintf("Caught fatal signal %d", result.ExitSig)
+	case status.ExitStatus() != 0:
+		result.Status = "RE"
+		result.ExitCode = status.ExitStatus()
+		result.Message = fmt.Sprintf("Exited with error status %d", result.ExitCode)
+	}
+	return result, nil
+}
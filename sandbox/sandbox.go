@@ -0,0 +1,83 @@
+// Package sandbox runs untrusted programs inside numbered boxes. Each box is
+// a host directory that files are put into and read back from; programs
+// started in it are confined and limited by the backend.
+package sandbox
+
+import (
+	"context"
+
+	"github.com/judgenot0/judge-deamon/config"
+)
+
+const (
+	BackendIsolate = "isolate"
+	BackendRlimit  = "rlimit"
+)
+
+type Sandbox interface {
+	// Init prepares an empty box. Cleanup removes it and everything in it.
+	Init(boxId int) error
+	Cleanup(boxId int) error
+	// BoxPath returns the host directory the box's files live in.
+	BoxPath(boxId int) string
+	PutFile(boxId int, name string, data []byte) error
+	GetFile(boxId int, name string) ([]byte, error)
+	// Run executes spec.Argv inside the box and reports how it ended. An
+	// error means the backend itself failed and says nothing about the
+	// program.
+	Run(ctx context.Context, boxId int, spec RunSpec) (*Result, error)
+}
+
+// Limits are enforced on a single run.
+type Limits struct {
+	Time      float32 // CPU seconds
+	WallTime  float32 // seconds
+	MemoryKB  int
+	FsizeKB   int // largest file the program may write
+	Processes int // 0 keeps the backend's default
+}
+
+type RunSpec struct {
+	Limits
+	// Stdin, Stdout and Stderr name files inside the box; empty means none.
+	// Output files are subject to FsizeKB like any other file.
+	Stdin  string
+	Stdout string
+	Stderr string
+	Argv   []string
+
+	// InputDir, when set, is a host directory the program may read but not
+	// write, and Stdin names a file in it instead of in the box. Tests are
+	// passed this way so they are not copied into every box.
+	InputDir string
+}
+
+// InputMount is where InputDir appears inside the sandbox.
+const InputMount = "/input"
+
+// Result describes a finished run in the terms of isolate's meta file, which
+// every backend fills in as far as it can.
+type Result struct {
+	Status        string // RE, SG, TO, XX, or empty on a clean exit
+	Message       string
+	Killed        int // Present when sandbox terminated the program (time/memory limit)
+	Time          float32
+	Time_Wall     float32
+	Max_RSS       float32
+	CG_Mem        float32
+	CG_OOM_Killed int
+	ExitCode      int // Added - for normal exits
+	ExitSig       int // Added - for signal deaths
+	CSW_Voluntary int // Added - optional, for debugging
+	CSW_Forced    int // Added - optional, for debugging
+}
+
+// New returns the backend selected by cfg.Sandbox.
+func New(cfg *config.Config) Sandbox {
+	switch cfg.Sandbox {
+	case BackendRlimit:
+		return NewRlimit(cfg.SandboxRoot)
+	default:
+		return NewIsolate(cfg.SandboxRoot)
+	}
+}
@@ -0,0 +1,205 @@
+// Package sandboxtest provides a scriptable in-memory stand-in for a
+// sandbox backend, so judging can be tested without isolate.
+package sandboxtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/judgenot0/judge-deamon/sandbox"
+)
+
+// Run is one call to Fake.Run, as seen by the script.
+type Run struct {
+	BoxId int
+	Spec  sandbox.RunSpec
+	Stdin []byte
+	// Ctx is done once the run is cancelled, for scripts that block.
+	Ctx context.Context
+}
+
+// Outcome is what a scripted run produces. Meta is the text of an isolate
+// meta file and is parsed the same way the real backend's is; Stdout is
+// written to the run's stdout file. A non-nil Err makes Run fail as if the
+// backend itself broke.
+type Outcome struct {
+	Meta   string
+	Stdout string
+	Err    error
+}
+
+// Fake keeps boxes as plain directories under root and answers every Run
+// from Script. The default script accepts and echoes stdin.
+type Fake struct {
+	root string
+
+	mu      sync.Mutex
+	script  func(Run) Outcome
+	runs    []Run
+	initErr func(boxId int) error
+}
+
+var _ sandbox.Sandbox = (*Fake)(nil)
+
+func NewFake(root string) *Fake {
+	return &Fake{
+		root: root,
+		script: func(r Run) Outcome {
+			return Accepted(string(r.Stdin))
+		},
+	}
+}
+
+// Script replaces the function deciding each run's outcome.
+func (f *Fake) Script(script func(Run) Outcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = script
+}
+
+// Sequence scripts the next runs to produce outcomes in order; runs past
+// the end repeat the last one.
+func (f *Fake) Sequence(outcomes ...Outcome) {
+	var mu sync.Mutex
+	next := 0
+	f.Script(func(Run) Outcome {
+		mu.Lock()
+		defer mu.Unlock()
+		outcome := outcomes[min(next, len(outcomes)-1)]
+		next++
+		return outcome
+	})
+}
+
+// FailInit makes Init return what fail returns for a box, failing when
+// that is not nil.
+func (f *Fake) FailInit(fail func(boxId int) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.initErr = fail
+}
+
+// Runs returns every run so far, in order.
+func (f *Fake) Runs() []Run {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Run(nil), f.runs...)
+}
+
+func (f *Fake) Init(boxId int) error {
+	f.mu.Lock()
+	fail := f.initErr
+	f.mu.Unlock()
+	if fail != nil {
+		if err := fail(boxId); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(f.BoxPath(boxId), 0o755)
+}
+
+func (f *Fake) Cleanup(boxId int) error {
+	return os.RemoveAll(filepath.Join(f.root, fmt.Sprint(boxId)))
+}
+
+func (f *Fake) BoxPath(boxId int) string {
+	return fmt.Sprintf("%s/%d/box/", f.root, boxId)
+}
+
+func (f *Fake) PutFile(boxId int, name string, data []byte) error {
+	return os.WriteFile(filepath.Join(f.BoxPath(boxId), name), data, 0644)
+}
+
+func (f *Fake) GetFile(boxId int, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(f.BoxPath(boxId), name))
+}
+
+func (f *Fake) Run(ctx context.Context, boxId int, spec sandbox.RunSpec) (*sandbox.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	run := Run{BoxId: boxId, Spec: spec, Ctx: ctx}
+	if spec.Stdin != "" {
+		var stdin []byte
+		var err error
+		if spec.InputDir != "" {
+			stdin, err = os.ReadFile(filepath.Join(spec.InputDir, spec.Stdin))
+		} else {
+			stdin, err = f.GetFile(boxId, spec.Stdin)
+		}
+		if err != nil {
+			return nil, err
+		}
+		run.Stdin = stdin
+	}
+
+	f.mu.Lock()
+	f.runs = append(f.runs, run)
+	script := f.script
+	f.mu.Unlock()
+
+	outcome := script(run)
+	if outcome.Err != nil {
+		return nil, outcome.Err
+	}
+	if spec.Stdout != "" {
+		if err := f.PutFile(boxId, spec.Stdout, []byte(outcome.Stdout)); err != nil {
+			return nil, err
+		}
+	}
+	return sandbox.ParseMeta([]byte(outcome.Meta)), nil
+}
+
+// Accepted is a clean exit that printed stdout.
+func Accepted(stdout string) Outcome {
+	return Outcome{
+		Meta:   "time:0.010\ntime-wall:0.020\nmax-rss:1024\nexitcode:0\n",
+		Stdout: stdout,
+	}
+}
+
+// TimeLimit is a run killed for exceeding its CPU time.
+func TimeLimit() Outcome {
+	return Outcome{Meta: "status:TO\nmessage:Time limit exceeded\nkilled:1\ntime:1.001\ntime-wall:1.050\nmax-rss:1024\n"}
+}
+
+// WallTimeLimit is a busy run stopped by the wall-clock limit before it used
+// its CPU time.
+func WallTimeLimit() Outcome {
+	return Outcome{Meta: "status:TO\nmessage:Time limit exceeded (wall clock)\nkilled:1\ntime:0.800\ntime-wall:2.000\nmax-rss:1024\n"}
+}
+
+// Idle is a run that sat blocked until the wall-clock limit.
+func Idle() Outcome {
+	return Outcome{Meta: "status:TO\nmessage:Time limit exceeded (wall clock)\nkilled:1\ntime:0.002\ntime-wall:2.000\nmax-rss:1024\n"}
+}
+
+// OutOfMemory is a run killed by the cgroup OOM killer.
+func OutOfMemory() Outcome {
+	return Outcome{Meta: "status:SG\nexitsig:9\nmessage:Caught fatal signal 9\ncg-oom-killed:1\ntime:0.200\ntime-wall:0.250\nmax-rss:262144\n"}
+}
+
+// Signal is a run that died from signal sig.
+func Signal(sig int) Outcome {
+	return Outcome{Meta: fmt.Sprintf("status:SG\nexitsig:%d\nmessage:Caught fatal signal %d\ntime:0.010\ntime-wall:0.020\nmax-rss:1024\n", sig, sig)}
+}
+
+// Exit is a run that exited with a non-zero code.
+func Exit(code int) Outcome {
+	return Outcome{Meta: fmt.Sprintf("status:RE\nexitcode:%d\nmessage:Exited with error status %d\ntime:0.010\ntime-wall:0.020\nmax-rss:1024\n", code, code)}
+}
+
+// OutputLimit is a run killed by SIGXFSZ for writing past its file size
+// limit.
+func OutputLimit() Outcome {
+	return Signal(25)
+}
+
+// SandboxError is isolate reporting an internal error (status XX).
+func SandboxError(message string) Outcome {
+	return Outcome{Meta: "status:XX\nmessage:" + message + "\n"}
+}
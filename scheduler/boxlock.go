@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var errBoxLocked = errors.New("box is locked by another process")
+
+// boxLock is an exclusive flock on a per-box file. It keeps two engines on
+// one host from using the same isolate box; the kernel drops it if the
+// process dies.
+type boxLock struct {
+	file *os.File
+}
+
+func lockBox(dir string, id int) (*boxLock, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating box lock directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("box-%d.lock", id)), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening box lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errBoxLocked
+		}
+		return nil, fmt.Errorf("locking box %d: %w", id, err)
+	}
+
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return &boxLock{file: file}, nil
+}
+
+func (l *boxLock) unlock() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
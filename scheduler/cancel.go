@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// cancelledRetention bounds how long a cancellation for a submission that is
+// not running here is remembered, waiting for it to be dequeued.
+const cancelledRetention = 24 * time.Hour
+
+var errCancelled = errors.New("submission cancelled")
+
+type cancellations struct {
+	mu sync.Mutex
+	// running holds the jobs judging each submission; a duplicate delivery
+	// or a rejudge can judge the same id twice at once.
+	running map[int64][]*runningJob
+	// pending holds when each submission that was not running here was
+	// cancelled; only messages enqueued before that are skipped.
+	pending map[int64]time.Time
+}
+
+type runningJob struct {
+	cancel context.CancelCauseFunc
+}
+
+func newCancellations() *cancellations {
+	return &cancellations{
+		running: make(map[int64][]*runningJob),
+		pending: make(map[int64]time.Time),
+	}
+}
+
+// cancel aborts the jobs for id running on this node, or if there are none
+// remembers the id so a message for it enqueued before now is skipped when
+// it is dequeued. It reports whether a running job was aborted.
+func (c *cancellations) cancel(id int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for pendingId, at := range c.pending {
+		if now.Sub(at) > cancelledRetention {
+			delete(c.pending, pendingId)
+		}
+	}
+
+	if jobs := c.running[id]; len(jobs) > 0 {
+		for _, job := range jobs {
+			job.cancel(errCancelled)
+		}
+		return true
+	}
+	c.pending[id] = now
+	return false
+}
+
+// isPending reports whether id was cancelled after its message, enqueued
+// at enqueuedAt, was published. A rejudge enqueued after the cancellation
+// is judged. A message without a timestamp may be the cancelled one, so it
+// is skipped, once.
+func (c *cancellations) isPending(id int64, enqueuedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isPendingLocked(id, enqueuedAt)
+}
+
+func (c *cancellations) isPendingLocked(id int64, enqueuedAt time.Time) bool {
+	cancelledAt, ok := c.pending[id]
+	if !ok {
+		return false
+	}
+	if enqueuedAt.IsZero() {
+		delete(c.pending, id)
+		return true
+	}
+	return enqueuedAt.Before(cancelledAt)
+}
+
+// track returns a context for judging id, whose message was enqueued at
+// enqueuedAt, that is cancelled by cancel, and a function to call once the
+// job has finished.
+func (c *cancellations) track(ctx context.Context, id int64, enqueuedAt time.Time) (context.Context, func()) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isPendingLocked(id, enqueuedAt) {
+		cancel(errCancelled)
+	}
+	job := &runningJob{cancel: cancel}
+	c.running[id] = append(c.running[id], job)
+
+	return jobCtx, func() {
+		c.mu.Lock()
+		jobs := slices.DeleteFunc(c.running[id], func(j *runningJob) bool { return j == job })
+		if len(jobs) == 0 {
+			delete(c.running, id)
+		} else {
+			c.running[id] = jobs
+		}
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func isCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCancelled)
+}
+
+// CancelResult splits cancelled ids into those running here and the rest,
+// which may be queued, judged already or unknown to this node.
+type CancelResult struct {
+	Running []int64 `json:"running"`
+	Pending []int64 `json:"pending"`
+}
+
+// Cancel stops judging the given submissions. Running jobs have their sandbox
+// killed; messages for the others enqueued before now are skipped when they
+// are dequeued. Both report the "cancelled" verdict.
+func (mngr *Scheduler) Cancel(ids []int64) CancelResult {
+	result := CancelResult{Running: []int64{}, Pending: []int64{}}
+	for _, id := range ids {
+		if mngr.cancels.cancel(id) {
+			result.Running = append(result.Running, id)
+		} else {
+			result.Pending = append(result.Pending, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/handlers"
+	"github.com/judgenot0/judge-deamon/languages"
+	"github.com/judgenot0/judge-deamon/sandbox/sandboxtest"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+const testEngineKey = "test-engine-key"
+
+// verdictServer stands in for the main server's verdict endpoint and
+// records every verdict it accepts.
+type verdictServer struct {
+	*httptest.Server
+	status atomic.Int32
+
+	mu       sync.Mutex
+	verdicts []handlers.EngineData
+}
+
+func newVerdictServer(t *testing.T) *verdictServer {
+	s := &verdictServer{}
+	s.status.Store(http.StatusOK)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/submissions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var payload struct {
+			Data        json.RawMessage `json:"payload"`
+			AccessToken string          `json:"access_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding verdict: %v", err)
+		}
+		mac := hmac.New(sha256.New, []byte(testEngineKey))
+		mac.Write(payload.Data)
+		if want := hex.EncodeToString(mac.Sum(nil)); payload.AccessToken != want {
+			t.Errorf("access token = %q, want %q", payload.AccessToken, want)
+		}
+
+		status := int(s.status.Load())
+		if status == http.StatusOK {
+			var data handlers.EngineData
+			if err := json.Unmarshal(payload.Data, &data); err != nil {
+				t.Errorf("decoding verdict payload: %v", err)
+			}
+			s.mu.Lock()
+			s.verdicts = append(s.verdicts, data)
+			s.mu.Unlock()
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// last returns the most recent verdict, failing the test if there is none.
+func (s *verdictServer) last(t *testing.T) handlers.EngineData {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.verdicts) == 0 {
+		t.Fatal("no verdict was reported")
+	}
+	return s.verdicts[len(s.verdicts)-1]
+}
+
+func (s *verdictServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.verdicts)
+}
+
+// fakeDelivery records how a message was settled.
+type fakeDelivery struct {
+	body       []byte
+	enqueuedAt time.Time
+	once       sync.Once
+	settled    chan struct{}
+	outcome    string // ack, nack or reject
+	reason     string
+}
+
+func newDelivery(body []byte) *fakeDelivery {
+	return &fakeDelivery{body: body, enqueuedAt: time.Now(), settled: make(chan struct{})}
+}
+
+func (d *fakeDelivery) Body() []byte { return d.body }
+
+func (d *fakeDelivery) EnqueuedAt() time.Time { return d.enqueuedAt }
+
+func (d *fakeDelivery) settle(outcome, reason string) error {
+	d.once.Do(func() {
+		d.outcome, d.reason = outcome, reason
+		close(d.settled)
+	})
+	return nil
+}
+
+func (d *fakeDelivery) Ack() error                 { return d.settle("ack", "") }
+func (d *fakeDelivery) Nack(reason string) error   { return d.settle("nack", reason) }
+func (d *fakeDelivery) Reject(reason string) error { return d.settle("reject", reason) }
+
+// wait blocks until the delivery is settled and returns how.
+func (d *fakeDelivery) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case <-d.settled:
+		return d.outcome
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was never settled")
+		return ""
+	}
+}
+
+type harness struct {
+	scheduler *Scheduler
+	sandbox   *sandboxtest.Fake
+	server    *verdictServer
+}
+
+// newHarness builds a scheduler with one worker on a fake sandbox, reporting
+// to a stub verdict server. The "fake" language has no compile step and
+// "broken" always fails to compile.
+func newHarness(t *testing.T) *harness {
+	server := newVerdictServer(t)
+
+	cfg := &config.Config{
+		WorkerCount:        1,
+		EngineKey:          testEngineKey,
+		ServerEndpoint:     server.URL,
+		MaxTimeLimit:       10,
+		MaxMemoryLimit:     1024,
+		MaxOutputLimit:     64,
+		MaxSourceSize:      64 * 1024,
+		MaxTestcaseSize:    1024 * 1024,
+		BoxIdMin:           0,
+		BoxIdMax:           3,
+		BoxLockDir:         t.TempDir(),
+		FsizeKB:            1024,
+		WallTimeMultiplier: 2,
+		CompileTimeout:     10 * time.Second,
+		CompileWorkers:     1,
+		WorkerWaitTimeout:  5 * time.Second,
+		VerdictTimeout:     5 * time.Second,
+		Languages: []config.Language{
+			{Name: "fake", SourceFile: "main.txt", Run: []string{"./main"}},
+			{Name: "broken", SourceFile: "main.txt", Compile: []string{"false"}, Run: []string{"./main"}},
+		},
+	}
+	if err := languages.Load(cfg.Languages); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { languages.Load(nil) })
+
+	fake := sandboxtest.NewFake(t.TempDir())
+	handler := handlers.NewHandler(cfg)
+	handler.Sandbox = fake
+
+	mngr := NewScheduler(handler)
+	if err := mngr.With(cfg.WorkerCount); err != nil {
+		t.Fatal(err)
+	}
+	// Let boxes finish resetting before their directories are removed.
+	t.Cleanup(func() { mngr.Shutdown(context.Background()) })
+	return &harness{scheduler: mngr, sandbox: fake, server: server}
+}
+
+// judge runs a submission through Work once a compile worker is free and
+// returns the delivery once it is settled.
+func (h *harness) judge(t *testing.T, submission *structs.Submission) *fakeDelivery {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.scheduler.compiles.acquire(ctx); err != nil {
+		t.Fatal("no compile worker became available")
+	}
+
+	body, _ := json.Marshal(submission)
+	d := newDelivery(body)
+	h.scheduler.Work(context.Background(), submission, d)
+	d.wait(t)
+	return d
+}
+
+func newSubmission(id int64, language string, tests ...structs.Testcase) *structs.Submission {
+	return &structs.Submission{
+		SubmissionId: &id,
+		Language:     language,
+		SourceCode:   "source",
+		Testcases:    tests,
+		TimeLimit:    1,
+		MemoryLimit:  256,
+	}
+}
+
+func testcase(input, expected string) structs.Testcase {
+	return structs.Testcase{Input: input, ExpectedOutput: expected}
+}
@@ -0,0 +1,357 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+// pool tracks initialized isolate boxes. Idle boxes wait in idle; busy boxes
+// are counted in busy from when they are handed out. Shrinking a pool with
+// busy boxes retires them as they are released.
+//
+// Boxes are taken from the id range [first, last], skipping any that another
+// engine on the host holds a lock on.
+//
+// Released boxes are reset in the background and only become idle again
+// once the reset succeeds. A box whose reset keeps failing is quarantined:
+// it stays out of the pool and is retried now and then until it recovers.
+// Shutting down stops the retries and waits for resets under way.
+type pool struct {
+	idle    chan structs.Worker
+	mu      sync.Mutex
+	boxes   map[int]*boxLock
+	retire  int
+	first   int
+	last    int
+	lockDir string
+	sandbox sandbox.Sandbox
+	// resizing serializes resize, which initializes boxes without mu.
+	resizing sync.Mutex
+
+	policy        resetPolicy
+	busy          int          // boxes handed out and not yet released
+	resetting     int          // boxes being reset, quarantined ones included
+	quarantined   map[int]bool // by box id
+	resetFailures int          // failed reset attempts since start
+	resets        sync.WaitGroup
+	stopped       bool
+	stop          chan struct{} // closed on shutdown
+}
+
+// resetPolicy says how failed resets are retried: attempts times with a
+// delay doubling from retryDelay, then every quarantineRetry.
+type resetPolicy struct {
+	attempts        int
+	retryDelay      time.Duration
+	quarantineRetry time.Duration
+}
+
+// PoolStats counts a pool's boxes by state.
+type PoolStats struct {
+	Target        int // worker_count
+	Idle          int
+	Busy          int
+	Resetting     int
+	Quarantined   int
+	ResetFailures int // failed reset attempts since start
+}
+
+func newPool(sb sandbox.Sandbox, first, last int, lockDir string) *pool {
+	return &pool{
+		idle:        make(chan structs.Worker, last-first+1),
+		boxes:       make(map[int]*boxLock),
+		first:       first,
+		last:        last,
+		lockDir:     lockDir,
+		sandbox:     sb,
+		quarantined: make(map[int]bool),
+		stop:        make(chan struct{}),
+	}
+}
+
+func (p *pool) setPolicy(cfg *config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policy = resetPolicy{
+		attempts:        cfg.ResetAttempts,
+		retryDelay:      cfg.ResetRetryDelay,
+		quarantineRetry: cfg.QuarantineRetry,
+	}
+}
+
+func (p *pool) resetBox(id int) error {
+	if err := p.sandbox.Cleanup(id); err != nil {
+		return fmt.Errorf("cleaning up: %w", err)
+	}
+	if err := p.sandbox.Init(id); err != nil {
+		return fmt.Errorf("reinitializing: %w", err)
+	}
+	return nil
+}
+
+// retireBox cleans up a box that left the pool and gives up its lock, so
+// the id is free for another engine.
+func (p *pool) retireBox(id int, lock *boxLock) {
+	if err := p.sandbox.Cleanup(id); err != nil {
+		log.Printf("Error cleaning up retired sandbox %d: %v", id, err)
+	}
+	lock.unlock()
+	log.Printf("Worker %d retired", id)
+}
+
+// size returns the number of boxes the pool is heading towards.
+func (p *pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.boxes) - p.retire
+}
+
+// resize grows or shrinks the pool to target boxes and returns the size it
+// reached. New boxes are initialized before they become available; idle
+// boxes are retired immediately and busy ones when they are released.
+// Boxes are initialized and cleaned up without holding mu, which releases
+// and resets of other boxes need meanwhile.
+func (p *pool) resize(target int) (int, error) {
+	if capacity := p.last - p.first + 1; target > capacity {
+		return p.size(), fmt.Errorf("worker count %d does not fit in box ids %d-%d", target, p.first, p.last)
+	}
+
+	// Only resize adds boxes, so ids seen free below stay free.
+	p.resizing.Lock()
+	defer p.resizing.Unlock()
+
+	type retiredBox struct {
+		id   int
+		lock *boxLock
+	}
+	var retired []retiredBox
+
+	p.mu.Lock()
+	current := len(p.boxes) - p.retire
+	for current < target && p.retire > 0 {
+		p.retire--
+		current++
+	}
+	for current > target {
+		select {
+		case w := <-p.idle:
+			retired = append(retired, retiredBox{w.Id, p.boxes[w.Id]})
+			delete(p.boxes, w.Id)
+		default:
+			p.retire++
+		}
+		current--
+	}
+	taken := make(map[int]bool, len(p.boxes))
+	for id := range p.boxes {
+		taken[id] = true
+	}
+	p.mu.Unlock()
+
+	for _, box := range retired {
+		p.retireBox(box.id, box.lock)
+	}
+
+	missing := target - current
+	for id := p.first; missing > 0 && id <= p.last; id++ {
+		if taken[id] {
+			continue
+		}
+		lock, err := lockBox(p.lockDir, id)
+		if errors.Is(err, errBoxLocked) {
+			log.Printf("Sandbox %d is in use by another engine, skipping", id)
+			continue
+		}
+		if err != nil {
+			return p.size(), err
+		}
+		if err := p.sandbox.Init(id); err != nil {
+			log.Printf("Error initializing sandbox for worker %d: %v", id, err)
+			lock.unlock()
+			continue
+		}
+		missing--
+
+		p.mu.Lock()
+		p.boxes[id] = lock
+		p.mu.Unlock()
+		p.idle <- structs.Worker{Id: id}
+		log.Printf("Worker %d initialized and added to pool", id)
+	}
+
+	return p.size(), nil
+}
+
+// get waits up to wait for an idle box. Return it with release.
+func (p *pool) get(ctx context.Context, wait time.Duration) (structs.Worker, error) {
+	select {
+	case w := <-p.idle:
+		p.mu.Lock()
+		p.busy++
+		p.mu.Unlock()
+		return w, nil
+	case <-ctx.Done():
+		return structs.Worker{}, ctx.Err()
+	case <-time.After(wait):
+		return structs.Worker{}, fmt.Errorf("waiting for a box: %w", ErrNoWorkers)
+	}
+}
+
+// take removes up to n idle boxes from the pool without waiting for busy
+// ones. Return them with release.
+func (p *pool) take(n int) []structs.Worker {
+	var taken []structs.Worker
+	defer func() {
+		p.mu.Lock()
+		p.busy += len(taken)
+		p.mu.Unlock()
+	}()
+	for len(taken) < n {
+		select {
+		case w := <-p.idle:
+			taken = append(taken, w)
+		default:
+			return taken
+		}
+	}
+	return taken
+}
+
+// release returns a box to the pool once it has been reset, unless the pool
+// is shrinking, in which case the box is retired. After shutdown the box is
+// left as it is; the next start initializes it afresh.
+func (p *pool) release(w structs.Worker) {
+	p.mu.Lock()
+	p.busy--
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.resetting++
+	p.resets.Add(1)
+	p.mu.Unlock()
+
+	if p.retireIfShrinking(w.Id, true) {
+		p.resets.Done()
+		return
+	}
+	go p.reset(w)
+}
+
+// retireIfShrinking retires box id if the pool is shrinking and reports
+// whether it did. resetting says whether the box was being reset.
+func (p *pool) retireIfShrinking(id int, resetting bool) bool {
+	p.mu.Lock()
+	if p.retire == 0 {
+		p.mu.Unlock()
+		return false
+	}
+	p.retire--
+	lock := p.boxes[id]
+	delete(p.boxes, id)
+	if resetting {
+		p.resetting--
+		delete(p.quarantined, id)
+	}
+	p.mu.Unlock()
+
+	p.retireBox(id, lock)
+	return true
+}
+
+// reset resets a released box until it succeeds and makes it idle again.
+// After policy.attempts failures in a row the box is quarantined and only
+// retried every policy.quarantineRetry.
+func (p *pool) reset(w structs.Worker) {
+	defer p.resets.Done()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := p.resetBox(w.Id)
+
+		p.mu.Lock()
+		policy := p.policy
+		if err == nil {
+			quarantined := p.quarantined[w.Id]
+			p.resetting--
+			delete(p.quarantined, w.Id)
+			p.mu.Unlock()
+
+			if quarantined {
+				log.Printf("Sandbox %d recovered after %d attempts, back in the pool", w.Id, attempt)
+			}
+			if !p.retireIfShrinking(w.Id, false) {
+				p.idle <- w
+			}
+			return
+		}
+		p.resetFailures++
+		if attempt == policy.attempts {
+			p.quarantined[w.Id] = true
+			usable := len(p.boxes) - p.retire - len(p.quarantined)
+			log.Printf("Warning: Sandbox %d failed to reset %d times and is quarantined; %d of %d boxes usable", w.Id, attempt, usable, len(p.boxes)-p.retire)
+		}
+		p.mu.Unlock()
+		log.Printf("Error resetting sandbox %d (attempt %d): %v", w.Id, attempt, err)
+
+		switch {
+		case attempt >= policy.attempts:
+			delay = policy.quarantineRetry
+		case attempt == 1:
+			delay = policy.retryDelay
+		default:
+			delay *= 2
+		}
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			return
+		}
+		if p.retireIfShrinking(w.Id, true) {
+			return
+		}
+	}
+}
+
+// shutdown stops retrying failed resets and waits until the resets under
+// way are done or ctx expires.
+func (p *pool) shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.resets.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for box resets: %w", ctx.Err())
+	}
+}
+
+func (p *pool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Boxes waiting to be retired are still busy or resetting.
+	return PoolStats{
+		Idle:          len(p.boxes) - p.busy - p.resetting,
+		Busy:          p.busy,
+		Resetting:     p.resetting - len(p.quarantined),
+		Quarantined:   len(p.quarantined),
+		ResetFailures: p.resetFailures,
+	}
+}
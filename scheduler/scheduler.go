@@ -2,63 +2,74 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/judgenot0/judge-deamon/broker"
+	"github.com/judgenot0/judge-deamon/config"
 	"github.com/judgenot0/judge-deamon/handlers"
 	"github.com/judgenot0/judge-deamon/languages"
 	"github.com/judgenot0/judge-deamon/structs"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type Runner interface {
-	Compile(ctx context.Context, boxId int, runReq *structs.Submission) (structs.Verdict, error)
-	Run(ctx context.Context, boxId int, runReq *structs.Submission, handler *handlers.Handler) structs.Verdict
+	// Compile builds a submission in dir, a workspace on the host.
+	Compile(ctx context.Context, dir string, runReq *structs.Submission, handler *handlers.Handler) (structs.Verdict, error)
+	// Run copies the submission compiled in dir into the boxes and judges
+	// it, sharing the tests between them.
+	Run(ctx context.Context, dir string, boxIds []int, runReq *structs.Submission, handler *handlers.Handler) structs.Verdict
 }
 
+// ErrNoWorkers means a job waited too long for a compile worker or a box.
+var ErrNoWorkers = errors.New("no workers available")
+
+// Scheduler judges submissions in two stages. A job first takes one of the
+// compile workers and compiles on the host, then waits for a box to run in.
+// It keeps its compile worker until it has a box, so no more compiled jobs
+// wait for boxes than there are compile workers.
 type Scheduler struct {
-	WorkChannel chan structs.Worker
-	WorkerCount int
-	Handler     *handlers.Handler
+	pool     *pool
+	compiles *slots
+	handler  atomic.Pointer[handlers.Handler]
+	cancels  *cancellations
 }
 
 func NewScheduler(handler *handlers.Handler) *Scheduler {
-	return &Scheduler{
-		Handler: handler,
+	cfg := handler.Config
+	pool := newPool(handler.Sandbox, cfg.BoxIdMin, cfg.BoxIdMax, cfg.BoxLockDir)
+	mngr := &Scheduler{
+		pool:     pool,
+		compiles: newSlots(cfg.CompileWorkers),
+		cancels:  newCancellations(),
 	}
+	pool.setPolicy(cfg)
+	mngr.handler.Store(handler)
+	return mngr
+}
+
+// Handler returns the handler built from the current configuration. Jobs
+// keep the handler they started with across a reload.
+func (mngr *Scheduler) Handler() *handlers.Handler {
+	return mngr.handler.Load()
 }
 
 func GetRunner(language string) Runner {
-	switch language {
-	case "c":
-		return &languages.C{}
-	case "cpp":
-		return &languages.CPP{}
-	case "py":
-		return &languages.Python{}
-	case "js", "javascript", "node", "nodejs":
-		return &languages.NodeJS{}
-	default:
-		return nil
+	if lang := languages.Lookup(language); lang != nil {
+		return lang
 	}
+	return nil
 }
 
 func (mngr *Scheduler) With(workerCount int) error {
-	mngr.WorkChannel = make(chan structs.Worker, workerCount)
-	mngr.WorkerCount = workerCount
-
-	initialized := 0
-	for i := 0; i < workerCount; i++ {
-		cmd := exec.Command("isolate", fmt.Sprintf("--box-id=%d", i), "--cg", "--init")
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error initializing sandbox for worker %d: %v", i, err)
-			continue
-		}
-
-		mngr.WorkChannel <- structs.Worker{Id: i}
-		initialized++
-		log.Printf("Worker %d initialized and added to pool", i)
+	initialized, err := mngr.pool.resize(workerCount)
+	if err != nil {
+		return err
 	}
 
 	if initialized == 0 {
@@ -72,41 +83,189 @@ func (mngr *Scheduler) With(workerCount int) error {
 	return nil
 }
 
-func (mngr *Scheduler) Work(ctx context.Context, w structs.Worker, submission *structs.Submission, d amqp.Delivery) {
+// Release hands a worker back. Its sandbox is reset in the background and
+// only becomes available again once that succeeds.
+func (mngr *Scheduler) Release(w structs.Worker) {
+	mngr.pool.release(w)
+}
+
+// Shutdown stops retrying sandboxes that failed to reset and waits for
+// resets under way, so no box is left half reset, until ctx expires.
+func (mngr *Scheduler) Shutdown(ctx context.Context) error {
+	return mngr.pool.shutdown(ctx)
+}
+
+// PoolStats counts the workers by state, for metrics.
+func (mngr *Scheduler) PoolStats() PoolStats {
+	stats := mngr.pool.stats()
+	stats.Target = mngr.Handler().Config.WorkerCount
+	return stats
+}
+
+// RunBoxes returns w's box followed by idle boxes borrowed for running a
+// submission's tests in parallel, at most parallel_tests in all and one per
+// test. Boxes are only borrowed if idle right now, so a busy node runs tests
+// one by one rather than holding up other submissions. release returns the
+// borrowed boxes to the pool.
+func (mngr *Scheduler) RunBoxes(cfg *config.Config, w structs.Worker, tests int) (boxIds []int, release func()) {
+	borrowed := mngr.pool.take(min(cfg.ParallelTests, tests) - 1)
+	boxIds = []int{w.Id}
+	for _, helper := range borrowed {
+		boxIds = append(boxIds, helper.Id)
+	}
+	return boxIds, func() {
+		for _, helper := range borrowed {
+			mngr.Release(helper)
+		}
+	}
+}
+
+// Reload applies a new configuration: the worker pool and compile workers
+// are resized, language definitions are swapped, and new jobs use the new
+// settings while running jobs finish with the ones they started with. The
+// steps that can fail come first, so a failed reload leaves the rest of the
+// settings as they were.
+func (mngr *Scheduler) Reload(cfg *config.Config) error {
+	if err := languages.Check(cfg.Languages); err != nil {
+		return fmt.Errorf("loading languages: %w", err)
+	}
+	size, err := mngr.pool.resize(cfg.WorkerCount)
+	if err != nil {
+		return err
+	}
+	if size < cfg.WorkerCount {
+		log.Printf("Warning: Only %d out of %d workers initialized", size, cfg.WorkerCount)
+	}
+
+	if err := languages.Load(cfg.Languages); err != nil {
+		return fmt.Errorf("loading languages: %w", err)
+	}
+	handler := handlers.NewHandler(cfg)
+	handler.Testcases = mngr.Handler().Testcases
+	handler.Artifacts = mngr.Handler().Artifacts
+	mngr.handler.Store(handler)
+	mngr.compiles.resize(cfg.CompileWorkers)
+	mngr.pool.setPolicy(cfg)
+	return nil
+}
+
+// Dispatch waits for a free compile worker and starts judging the delivery.
+// It blocks while every compile worker is busy, which throttles the
+// consumer.
+func (mngr *Scheduler) Dispatch(ctx context.Context, d broker.Delivery) {
+	var submission structs.Submission
+	if err := json.Unmarshal(d.Body(), &submission); err != nil {
+		log.Printf("Raw body: %s", string(d.Body()))
+		log.Printf("Invalid message body: %v", err)
+		id, _ := broker.MessageInfo(d.Body())
+		submission = structs.Submission{SubmissionId: id}
+		mngr.rejectInvalid(d, &submission, "invalid message body: "+err.Error())
+		return
+	}
+	handler := mngr.Handler()
+	if errs := ValidateSubmission(&submission, handler.Config, true); errs != nil {
+		log.Printf("Invalid submission %d: %v", getSubmissionID(&submission), errs)
+		mngr.rejectInvalid(d, &submission, "invalid submission: "+errs.Error())
+		return
+	}
+	if mngr.cancels.isPending(*submission.SubmissionId, d.EnqueuedAt()) {
+		log.Printf("Submission %d was cancelled, skipping", *submission.SubmissionId)
+		mngr.settleWithVerdict(d, &submission, "cancelled")
+		return
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, handler.Config.WorkerWaitTimeout)
+	defer cancelWait()
+	if err := mngr.compiles.acquire(waitCtx); err != nil {
+		if ctx.Err() != nil {
+			log.Println("Context cancelled, nacking message to DLQ and stopping")
+			nack(d, "engine shutting down")
+			return
+		}
+		log.Printf("Warning: No compile workers available for %v, message sent to DLQ", handler.Config.WorkerWaitTimeout)
+		nack(d, ErrNoWorkers.Error())
+		return
+	}
+	go mngr.Work(ctx, &submission, d)
+}
+
+// rejectInvalid settles a message that can never be judged. If it carries a
+// submission id the "invalid" verdict is reported and the message acked;
+// otherwise there is no one to report to and it is parked. Retrying either
+// would only fail again.
+func (mngr *Scheduler) rejectInvalid(d broker.Delivery, submission *structs.Submission, reason string) {
+	if submission.SubmissionId == nil {
+		if err := d.Reject(reason); err != nil {
+			log.Printf("Error rejecting message: %v", err)
+		}
+		return
+	}
+
+	mngr.settleWithVerdict(d, submission, "invalid")
+}
+
+// settleWithVerdict reports result without judging and acks the message, or
+// nacks it if the verdict could not be delivered.
+func (mngr *Scheduler) settleWithVerdict(d broker.Delivery, submission *structs.Submission, result string) {
+	ackStatus := true
+	mngr.Handler().ProduceVerdict(&structs.Verdict{
+		Submission: submission,
+		Result:     result,
+	}, &ackStatus)
+	if !ackStatus {
+		nack(d, "failed to report "+result+" verdict")
+		return
+	}
+	if err := d.Ack(); err != nil {
+		log.Printf("Error acknowledging message: %v", err)
+	}
+}
+
+func nack(d broker.Delivery, reason string) {
+	if err := d.Nack(reason); err != nil {
+		log.Printf("Error nacking message: %v", err)
+	}
+}
+
+// Work judges a submission for which the caller took a compile worker, and
+// settles its message.
+func (mngr *Scheduler) Work(ctx context.Context, submission *structs.Submission, d broker.Delivery) {
+	compiled := sync.OnceFunc(mngr.compiles.release)
+
 	// if true we need to ack the message queue
 	ackStatus := true
+	nackReason := "failed to report verdict"
 
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Panic in scheduler.Work: %v", r)
 			ackStatus = false
+			nackReason = fmt.Sprintf("panic while judging: %v", r)
 		}
 
-		cmd := exec.Command("isolate", fmt.Sprintf("--box-id=%d", w.Id), "--cg", "--cleanup")
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error cleaning up sandbox %d: %v", w.Id, err)
-		}
-		cmd = exec.Command("isolate", fmt.Sprintf("--box-id=%d", w.Id), "--cg", "--init")
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error reinitializing sandbox %d: %v", w.Id, err)
-		}
 		if !ackStatus {
-			if err := d.Nack(false, false); err != nil {
-				log.Printf("Error nacking message: %v", err)
-			}
+			nack(d, nackReason)
 		} else {
-			if err := d.Ack(false); err != nil {
+			if err := d.Ack(); err != nil {
 				log.Printf("Error acknowledging message: %v", err)
 			}
 		}
 
-		mngr.WorkChannel <- w
+		compiled()
 	}()
 
-	mngr.processWork(ctx, w, submission, &ackStatus)
+	jobCtx, finish := mngr.cancels.track(ctx, getSubmissionID(submission), d.EnqueuedAt())
+	defer finish()
+
+	if err := mngr.processWork(jobCtx, mngr.Handler(), submission, compiled, &ackStatus); err != nil {
+		ackStatus = false
+		nackReason = err.Error()
+	}
 }
 
-func (mngr *Scheduler) processWork(ctx context.Context, w structs.Worker, submission *structs.Submission, ackStatus *bool) {
+// processWork judges a submission and reports the verdict. It returns an
+// error instead, without reporting, when the job should be retried later.
+func (mngr *Scheduler) processWork(ctx context.Context, handler *handlers.Handler, submission *structs.Submission, compiled func(), ackStatus *bool) (retry error) {
 
 	verdict := structs.Verdict{
 		Submission: submission,
@@ -116,7 +275,15 @@ func (mngr *Scheduler) processWork(ctx context.Context, w structs.Worker, submis
 	}
 
 	defer func() {
-		mngr.Handler.ProduceVerdict(&verdict, ackStatus)
+		if isCancelled(ctx) {
+			log.Printf("Submission %d cancelled while judging", getSubmissionID(submission))
+			verdict = structs.Verdict{Submission: submission, Result: "cancelled"}
+			retry = nil
+		}
+		if retry != nil {
+			return
+		}
+		handler.ProduceVerdict(&verdict, ackStatus)
 	}()
 
 	if submission.Language == "" {
@@ -138,15 +305,78 @@ func (mngr *Scheduler) processWork(ctx context.Context, w structs.Worker, submis
 		return
 	}
 
-	var err error
-	verdict, err = runner.Compile(ctx, w.Id, submission)
+	// The server may be briefly unreachable, so a failed fetch is retried
+	// rather than judged.
+	release, err := handler.Testcases.Resolve(ctx, handler.Config, submission)
+	if err != nil {
+		log.Printf("Error fetching testcases for submission %d: %v", getSubmissionID(submission), err)
+		return fmt.Errorf("fetching testcases: %w", err)
+	}
+	defer release()
+
+	verdict, err = mngr.judge(ctx, handler, runner, submission, compiled, handler.Config.WorkerWaitTimeout)
+	if err != nil {
+		log.Printf("Submission %d not judged: %v", getSubmissionID(submission), err)
+		return err
+	}
+	return nil
+}
+
+// Judge compiles and runs a submission outside the queue, waiting up to
+// wait for a compile worker and then for a box.
+func (mngr *Scheduler) Judge(ctx context.Context, handler *handlers.Handler, submission *structs.Submission, wait time.Duration) (structs.Verdict, error) {
+	runner := GetRunner(submission.Language)
+	if runner == nil || submission.SourceCode == "" {
+		return structs.Verdict{Submission: submission, Result: "ce"}, nil
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, wait)
+	defer cancelWait()
+	if err := mngr.compiles.acquire(waitCtx); err != nil {
+		if ctx.Err() != nil {
+			return structs.Verdict{}, ctx.Err()
+		}
+		return structs.Verdict{}, ErrNoWorkers
+	}
+	compiled := sync.OnceFunc(mngr.compiles.release)
+	defer compiled()
+
+	return mngr.judge(ctx, handler, runner, submission, compiled, wait)
+}
+
+// judge compiles a submission in a workspace and runs it. The caller's
+// compile worker is given back through compiled once a box is free, so
+// compilation goes on while the box is busy with an earlier job. An error
+// means the submission was not judged.
+func (mngr *Scheduler) judge(ctx context.Context, handler *handlers.Handler, runner Runner, submission *structs.Submission, compiled func(), wait time.Duration) (structs.Verdict, error) {
+	workspace, err := os.MkdirTemp("", "judge-compile-")
+	if err != nil {
+		log.Printf("Error creating compile workspace: %v", err)
+		return structs.Verdict{Submission: submission, Result: "ie"}, nil
+	}
+	defer os.RemoveAll(workspace)
+
+	compileCtx, cancelCompile := context.WithTimeout(ctx, handler.Config.CompileTimeout)
+	verdict, err := runner.Compile(compileCtx, workspace, submission, handler)
+	cancelCompile()
 	if err != nil {
 		log.Printf("Compilation error for submission %d: %v", getSubmissionID(submission), err)
+		verdict.Submission = submission
 		verdict.Result = "ce"
-		return
+		return verdict, nil
 	}
 
-	verdict = runner.Run(ctx, w.Id, submission, mngr.Handler)
+	w, err := mngr.pool.get(ctx, wait)
+	compiled()
+	if err != nil {
+		return structs.Verdict{}, err
+	}
+	defer mngr.Release(w)
+
+	boxIds, releaseBoxes := mngr.RunBoxes(handler.Config, w, len(submission.Testcases))
+	defer releaseBoxes()
+
+	return runner.Run(ctx, workspace, boxIds, submission, handler), nil
 }
 
 func getSubmissionID(submission *structs.Submission) int64 {
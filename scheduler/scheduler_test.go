@@ -0,0 +1,949 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/artifacts"
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/languages"
+	"github.com/judgenot0/judge-deamon/sandbox/sandboxtest"
+	"github.com/judgenot0/judge-deamon/structs"
+	"github.com/judgenot0/judge-deamon/testcases"
+)
+
+func TestWorkVerdicts(t *testing.T) {
+	h := newHarness(t)
+
+	tests := []struct {
+		name    string
+		outcome sandboxtest.Outcome
+		want    string
+	}{
+		{"accepted", sandboxtest.Accepted("2\n"), "ac"},
+		{"wrong answer", sandboxtest.Accepted("3\n"), "wa"},
+		{"time limit", sandboxtest.TimeLimit(), "tle"},
+		{"wall time limit", sandboxtest.WallTimeLimit(), "wtle"},
+		{"idleness limit", sandboxtest.Idle(), "ile"},
+		{"memory limit", sandboxtest.OutOfMemory(), "mle"},
+		{"segfault", sandboxtest.Signal(11), "re"},
+		{"non-zero exit", sandboxtest.Exit(1), "re"},
+		{"output limit", sandboxtest.OutputLimit(), "ole"},
+		{"sandbox error", sandboxtest.SandboxError("cannot run proxy"), "ie"},
+		{"backend failure", sandboxtest.Outcome{Err: errors.New("isolate not found")}, "ie"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.sandbox.Sequence(tt.outcome)
+			d := h.judge(t, newSubmission(int64(i+1), "fake", testcase("1\n", "2\n")))
+
+			if d.outcome != "ack" {
+				t.Errorf("delivery %s (%s), want ack", d.outcome, d.reason)
+			}
+			verdict := h.server.last(t)
+			if verdict.SubmissionId != int64(i+1) || verdict.Verdict != tt.want {
+				t.Errorf("verdict = %d/%s, want %d/%s", verdict.SubmissionId, verdict.Verdict, i+1, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkStopsAtFirstFailure(t *testing.T) {
+	h := newHarness(t)
+	h.sandbox.Sequence(sandboxtest.Accepted("a\n"), sandboxtest.TimeLimit(), sandboxtest.Accepted("c\n"))
+
+	h.judge(t, newSubmission(1, "fake", testcase("", "a\n"), testcase("", "b\n"), testcase("", "c\n")))
+
+	if got := h.server.last(t).Verdict; got != "tle" {
+		t.Errorf("verdict = %s, want tle", got)
+	}
+	if runs := len(h.sandbox.Runs()); runs != 2 {
+		t.Errorf("%d runs, want 2", runs)
+	}
+}
+
+func TestWorkPassesInputAndLimits(t *testing.T) {
+	h := newHarness(t)
+
+	h.judge(t, newSubmission(1, "fake", testcase("first\n", "first\n"), testcase("second\n", "second\n")))
+
+	if got := h.server.last(t); got.Verdict != "ac" || got.ExecutionTime == nil || got.ExecutionMemory == nil {
+		t.Fatalf("verdict = %+v, want ac with time and memory", got)
+	}
+	runs := h.sandbox.Runs()
+	if len(runs) != 2 || string(runs[1].Stdin) != "second\n" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	limits := runs[0].Spec.Limits
+	if limits.Time != 1 || limits.WallTime != 2 || limits.MemoryKB != 256*1024 || limits.FsizeKB != 1024 {
+		t.Errorf("limits = %+v", limits)
+	}
+}
+
+func TestWorkRuntimeErrorDetails(t *testing.T) {
+	h := newHarness(t)
+	h.sandbox.Sequence(sandboxtest.Accepted("1\n"), sandboxtest.Signal(11))
+
+	h.judge(t, newSubmission(1, "fake", testcase("", "1\n"), testcase("", "2\n"), testcase("", "3\n")))
+
+	verdict := h.server.last(t)
+	if verdict.Verdict != "re" || verdict.RuntimeError == nil || verdict.RuntimeError.Signal != "SIGSEGV" {
+		t.Fatalf("verdict = %+v, want re with SIGSEGV", verdict)
+	}
+	if len(verdict.Tests) != 2 {
+		t.Fatalf("%d test results, want 2", len(verdict.Tests))
+	}
+	if got := verdict.Tests[0]; got.Index != 0 || got.Result != "ac" || got.RuntimeError != nil {
+		t.Errorf("first test = %+v, want ac", got)
+	}
+	if got := verdict.Tests[1]; got.Index != 1 || got.Result != "re" || got.RuntimeError == nil || got.RuntimeError.Hint == "" {
+		t.Errorf("second test = %+v, want re with a hint", got)
+	}
+}
+
+func TestWorkWrongAnswerDiagnostics(t *testing.T) {
+	for _, diagnostics := range []bool{false, true} {
+		h := newHarness(t)
+		h.sandbox.Sequence(sandboxtest.Accepted("1\n"), sandboxtest.Accepted("1\n2\n4\n"))
+		submission := newSubmission(1, "fake", testcase("", "1\n"), testcase("", "1\n2\n3\n"))
+		submission.CheckerDiagnostics = diagnostics
+
+		h.judge(t, submission)
+
+		verdict := h.server.last(t)
+		if verdict.Verdict != "wa" || len(verdict.Tests) != 2 {
+			t.Fatalf("verdict = %+v, want wa after two tests", verdict)
+		}
+		if !diagnostics {
+			if verdict.WrongAnswer != nil || verdict.Tests[1].WrongAnswer != nil {
+				t.Errorf("diagnostics reported without checker_diagnostics: %+v", verdict.WrongAnswer)
+			}
+			continue
+		}
+		want := structs.WrongAnswer{Line: 3, ExpectedLine: 3, Column: 1, Output: "4", Expected: "3", Reason: "different"}
+		got := verdict.WrongAnswer
+		if got == nil || verdict.Tests[1].WrongAnswer == nil {
+			t.Fatal("no diagnostics reported")
+		}
+		got.Message = ""
+		if *got != want {
+			t.Errorf("wrong answer = %+v, want %+v", *got, want)
+		}
+	}
+}
+
+func TestWorkPartialScoring(t *testing.T) {
+	h := newHarness(t)
+	h.sandbox.Sequence(sandboxtest.Accepted("1\n"), sandboxtest.Accepted("0\n"), sandboxtest.Signal(11), sandboxtest.Accepted("4\n"))
+	submission := newSubmission(1, "fake", testcase("", "1\n"), testcase("", "2\n"), testcase("", "3\n"), testcase("", "4\n"))
+	submission.PartialScoring = true
+
+	h.judge(t, submission)
+
+	verdict := h.server.last(t)
+	if verdict.Verdict != "wa" || verdict.RuntimeError != nil {
+		t.Errorf("verdict = %+v, want the first failure, wa", verdict)
+	}
+	if verdict.Score == nil || *verdict.Score != 0.5 {
+		t.Errorf("score = %v, want 0.5", verdict.Score)
+	}
+	if len(verdict.Tests) != 4 || verdict.Tests[2].Result != "re" || verdict.Tests[3].Score != 1 {
+		t.Errorf("tests = %+v, want all four run", verdict.Tests)
+	}
+}
+
+func TestWorkParallelTests(t *testing.T) {
+	h := newHarness(t)
+	if err := h.scheduler.With(3); err != nil {
+		t.Fatal(err)
+	}
+	h.scheduler.Handler().Config.ParallelTests = 3
+
+	var mu sync.Mutex
+	boxes := make(map[int]bool)
+	slowStarted := make(chan struct{})
+	slowCancelled := false
+	h.sandbox.Script(func(r sandboxtest.Run) sandboxtest.Outcome {
+		mu.Lock()
+		_, err := os.Stat(filepath.Join(h.sandbox.BoxPath(r.BoxId), "main.txt"))
+		boxes[r.BoxId] = err == nil
+		mu.Unlock()
+
+		switch string(r.Stdin) {
+		case "1\n":
+			// Fail only once a later test is running, which must then stop.
+			select {
+			case <-slowStarted:
+			case <-time.After(5 * time.Second):
+			}
+			return sandboxtest.Accepted("wrong\n")
+		case "3\n":
+			close(slowStarted)
+			select {
+			case <-r.Ctx.Done():
+				mu.Lock()
+				slowCancelled = true
+				mu.Unlock()
+				return sandboxtest.Outcome{Err: r.Ctx.Err()}
+			case <-time.After(5 * time.Second):
+				return sandboxtest.Accepted("3\n")
+			}
+		}
+		return sandboxtest.Accepted(string(r.Stdin))
+	})
+
+	var tests []structs.Testcase
+	for i := range 5 {
+		tests = append(tests, testcase(fmt.Sprintf("%d\n", i), fmt.Sprintf("%d\n", i)))
+	}
+	h.judge(t, newSubmission(1, "fake", tests...))
+
+	verdict := h.server.last(t)
+	if verdict.Verdict != "wa" || len(verdict.Tests) != 2 || verdict.Tests[1].Index != 1 || verdict.Tests[1].Result != "wa" {
+		t.Errorf("verdict = %+v, want wa on test 1 with tests 0 and 1 reported", verdict)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slowCancelled {
+		t.Error("test after the failure was not cancelled")
+	}
+	// Test 1 only fails once test 3 runs, so they ran side by side.
+	if len(boxes) < 2 {
+		t.Errorf("tests ran on boxes %v, want several", boxes)
+	}
+	for id, copied := range boxes {
+		if !copied {
+			t.Errorf("box %d ran without the submission", id)
+		}
+	}
+	// Boxes are reset in the background after the job.
+	deadline := time.Now().Add(5 * time.Second)
+	for h.scheduler.PoolStats().Idle != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := h.scheduler.PoolStats(); stats.Idle != 3 || stats.Busy != 0 {
+		t.Errorf("stats after the job = %+v, want 3 idle", stats)
+	}
+}
+
+func TestWorkReferencedTestcases(t *testing.T) {
+	files := map[string]string{}
+	for _, content := range []string{"6 7\n", "42\n"} {
+		sum := sha256.Sum256([]byte(content))
+		files[hex.EncodeToString(sum[:])] = content
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[strings.TrimPrefix(r.URL.Path, "/files/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	h := newHarness(t)
+	handler := h.scheduler.Handler()
+	handler.Config.TestcaseEndpoint = server.URL
+	handler.Config.TestcaseFetchTimeout = 5 * time.Second
+	store, err := testcases.New(t.TempDir(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Testcases = store
+
+	var reference structs.Testcase
+	for sum, content := range files {
+		if content == "6 7\n" {
+			reference.InputSha256 = sum
+		} else {
+			reference.ExpectedOutputSha256 = sum
+		}
+	}
+	h.sandbox.Sequence(sandboxtest.Accepted("42\n"))
+	h.judge(t, newSubmission(1, "fake", reference))
+
+	if got := h.server.last(t).Verdict; got != "ac" {
+		t.Errorf("verdict = %s, want ac", got)
+	}
+	if runs := h.sandbox.Runs(); len(runs) != 1 || string(runs[0].Stdin) != "6 7\n" {
+		t.Errorf("runs = %+v, want the referenced input", runs)
+	}
+
+	// A file the server cannot provide is retried later, not judged.
+	reported := h.server.count()
+	d := h.judge(t, newSubmission(2, "fake", structs.Testcase{InputSha256: strings.Repeat("0", 64), ExpectedOutputSha256: reference.ExpectedOutputSha256}))
+	if d.outcome != "nack" || h.server.count() != reported {
+		t.Errorf("delivery %s with %d new verdicts, want a nack and none", d.outcome, h.server.count()-reported)
+	}
+}
+
+func TestWorkStagesTestsOutsideBox(t *testing.T) {
+	h := newHarness(t)
+	var boxFiles []string
+	h.sandbox.Script(func(r sandboxtest.Run) sandboxtest.Outcome {
+		entries, _ := os.ReadDir(h.sandbox.BoxPath(r.BoxId))
+		for _, e := range entries {
+			boxFiles = append(boxFiles, e.Name())
+		}
+		return sandboxtest.Accepted(string(r.Stdin))
+	})
+	h.judge(t, newSubmission(1, "fake", testcase("1\n", "1\n"), testcase("2\n", "2\n")))
+
+	if got := h.server.last(t).Verdict; got != "ac" {
+		t.Errorf("verdict = %s, want ac", got)
+	}
+	runs := h.sandbox.Runs()
+	if len(runs) != 2 || runs[0].Spec.InputDir == "" || string(runs[1].Stdin) != "2\n" {
+		t.Fatalf("runs = %+v, want inputs read from the stage", runs)
+	}
+	for _, name := range boxFiles {
+		if name != "main.txt" && name != "out.txt" {
+			t.Errorf("box holds %s, want only the program and its output", name)
+		}
+	}
+	if _, err := os.Stat(runs[0].Spec.InputDir); !os.IsNotExist(err) {
+		t.Errorf("stage left behind: %v", err)
+	}
+}
+
+func TestWorkOutputLimit(t *testing.T) {
+	h := newHarness(t)
+	submission := newSubmission(1, "fake", testcase("1\n", "1\n"))
+	submission.OutputLimit = 2
+
+	h.judge(t, submission)
+
+	spec := h.sandbox.Runs()[0].Spec
+	if spec.FsizeKB != 2048 || spec.Stderr == "" {
+		t.Errorf("spec = %+v, want a 2048 KB limit and captured stderr", spec)
+	}
+}
+
+func TestWorkFloatModes(t *testing.T) {
+	relativeError := 1e-6
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"absolute", "wa"},
+		{"relative", "ac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			h := newHarness(t)
+			h.sandbox.Sequence(sandboxtest.Accepted("1000000001\n"))
+			submission := newSubmission(1, "fake", testcase("1\n", "1e9\n"))
+			submission.CheckerType = "float"
+			submission.CheckerFloatMode = tt.mode
+			submission.CheckerRelativeError = &relativeError
+
+			h.judge(t, submission)
+
+			if got := h.server.last(t).Verdict; got != tt.want {
+				t.Errorf("verdict = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChecker(t *testing.T) {
+	h := newHarness(t)
+	zero := 0.0
+	submission := newSubmission(1, "fake", testcase("1\n", "1\n"))
+	submission.CheckerType = "unordered"
+	submission.CheckerFloatMode = "ulp"
+	submission.CheckerAbsoluteError = &zero
+
+	errs := ValidateSubmission(submission, h.scheduler.Handler().Config, true)
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	if len(errs) != 3 || !fields["checker_type"] || !fields["checker_float_mode"] || !fields["checker_absolute_error"] {
+		t.Errorf("errors = %v, want checker_type, checker_float_mode and checker_absolute_error", errs)
+	}
+}
+
+func TestWorkLanguageWallTimeMultiplier(t *testing.T) {
+	h := newHarness(t)
+	err := languages.Load([]config.Language{
+		{Name: "fake", SourceFile: "main.txt", Run: []string{"./main"}},
+		{Name: "slow", SourceFile: "main.txt", Run: []string{"./main"}, WallTimeMultiplier: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.judge(t, newSubmission(1, "slow", testcase("1\n", "1\n")))
+	h.judge(t, newSubmission(2, "fake", testcase("1\n", "1\n")))
+
+	runs := h.sandbox.Runs()
+	if runs[0].Spec.WallTime != 3 || runs[1].Spec.WallTime != 2 {
+		t.Errorf("wall times = %v, %v, want 3 (language) and 2 (global)", runs[0].Spec.WallTime, runs[1].Spec.WallTime)
+	}
+}
+
+func TestWorkCompileError(t *testing.T) {
+	h := newHarness(t)
+
+	d := h.judge(t, newSubmission(1, "broken", testcase("", "")))
+
+	if d.outcome != "ack" {
+		t.Errorf("delivery %s, want ack", d.outcome)
+	}
+	if got := h.server.last(t).Verdict; got != "ce" {
+		t.Errorf("verdict = %s, want ce", got)
+	}
+	if runs := len(h.sandbox.Runs()); runs != 0 {
+		t.Errorf("%d runs after a compile error", runs)
+	}
+}
+
+func TestWorkReusesCompiledArtifacts(t *testing.T) {
+	h := newHarness(t)
+	counter := filepath.Join(t.TempDir(), "compiles")
+	err := languages.Load([]config.Language{{
+		Name:       "counted",
+		SourceFile: "main.txt",
+		Compile:    []string{"sh", "-c", "echo >> " + counter + " && cp main.txt main"},
+		Binary:     "main",
+		Run:        []string{"./main"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := artifacts.New(t.TempDir(), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.scheduler.Handler().Artifacts = store
+
+	first := newSubmission(1, "counted", testcase("1\n", "1\n"))
+	h.judge(t, first)
+	rejudge := newSubmission(2, "counted", testcase("1\n", "1\n"))
+	h.judge(t, rejudge)
+	changed := newSubmission(3, "counted", testcase("1\n", "1\n"))
+	changed.SourceCode = "other source"
+	h.judge(t, changed)
+
+	if got := h.server.last(t).Verdict; got != "ac" {
+		t.Errorf("verdict = %s, want ac", got)
+	}
+	data, _ := os.ReadFile(counter)
+	if compiles := strings.Count(string(data), "\n"); compiles != 2 {
+		t.Errorf("%d compilations, want 2: the rejudge reuses the first", compiles)
+	}
+	if runs := len(h.sandbox.Runs()); runs != 3 {
+		t.Errorf("%d runs, want 3", runs)
+	}
+}
+
+func TestWorkNacksWhenVerdictIsRefused(t *testing.T) {
+	h := newHarness(t)
+	h.server.status.Store(http.StatusInternalServerError)
+
+	d := h.judge(t, newSubmission(1, "fake", testcase("1\n", "1\n")))
+
+	if d.outcome != "nack" {
+		t.Errorf("delivery %s, want nack", d.outcome)
+	}
+}
+
+func TestWorkNacksOnPanic(t *testing.T) {
+	h := newHarness(t)
+	h.sandbox.Script(func(sandboxtest.Run) sandboxtest.Outcome { panic("boom") })
+
+	d := h.judge(t, newSubmission(1, "fake", testcase("1\n", "1\n")))
+
+	if d.outcome != "nack" {
+		t.Errorf("delivery %s, want nack", d.outcome)
+	}
+	// The worker must still be returned to the pool.
+	h.sandbox.Sequence(sandboxtest.Accepted("1\n"))
+	h.judge(t, newSubmission(2, "fake", testcase("1\n", "1\n")))
+}
+
+func TestDispatch(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	t.Run("judges valid submissions", func(t *testing.T) {
+		body, _ := json.Marshal(newSubmission(1, "fake", testcase("1\n", "1\n")))
+		d := newDelivery(body)
+		h.scheduler.Dispatch(ctx, d)
+		if outcome := d.wait(t); outcome != "ack" {
+			t.Errorf("delivery %s, want ack", outcome)
+		}
+		if got := h.server.last(t).Verdict; got != "ac" {
+			t.Errorf("verdict = %s, want ac", got)
+		}
+	})
+
+	t.Run("rejects unparseable bodies", func(t *testing.T) {
+		reported := h.server.count()
+		d := newDelivery([]byte("not json"))
+		h.scheduler.Dispatch(ctx, d)
+		if outcome := d.wait(t); outcome != "reject" {
+			t.Errorf("delivery %s, want reject", outcome)
+		}
+		if h.server.count() != reported {
+			t.Error("a verdict was reported for a message without a submission id")
+		}
+	})
+
+	t.Run("reports invalid submissions", func(t *testing.T) {
+		body, _ := json.Marshal(newSubmission(2, "fake"))
+		d := newDelivery(body)
+		h.scheduler.Dispatch(ctx, d)
+		if outcome := d.wait(t); outcome != "ack" {
+			t.Errorf("delivery %s, want ack", outcome)
+		}
+		if got := h.server.last(t); got.SubmissionId != 2 || got.Verdict != "invalid" {
+			t.Errorf("verdict = %d/%s, want 2/invalid", got.SubmissionId, got.Verdict)
+		}
+	})
+
+	t.Run("nacks when shutting down", func(t *testing.T) {
+		reported := h.server.count()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		for _, language := range []string{"fake", "broken"} {
+			body, _ := json.Marshal(newSubmission(4, language, testcase("1\n", "1\n")))
+			d := newDelivery(body)
+			h.scheduler.Dispatch(cancelled, d)
+			if outcome := d.wait(t); outcome != "nack" {
+				t.Errorf("%s: delivery %s, want nack", language, outcome)
+			}
+		}
+		if h.server.count() != reported {
+			t.Error("a verdict was reported while shutting down")
+		}
+	})
+
+	t.Run("skips cancelled submissions", func(t *testing.T) {
+		body, _ := json.Marshal(newSubmission(3, "fake", testcase("1\n", "1\n")))
+		d := newDelivery(body)
+		if result := h.scheduler.Cancel([]int64{3}); len(result.Pending) != 1 {
+			t.Errorf("cancel = %+v, want the id pending", result)
+		}
+		runs := len(h.sandbox.Runs())
+		h.scheduler.Dispatch(ctx, d)
+		if outcome := d.wait(t); outcome != "ack" {
+			t.Errorf("delivery %s, want ack", outcome)
+		}
+		if got := h.server.last(t); got.SubmissionId != 3 || got.Verdict != "cancelled" {
+			t.Errorf("verdict = %d/%s, want 3/cancelled", got.SubmissionId, got.Verdict)
+		}
+		if len(h.sandbox.Runs()) != runs {
+			t.Error("a cancelled submission was run")
+		}
+
+		// A rejudge enqueued after the cancellation is judged.
+		rejudge := newDelivery(body)
+		h.scheduler.Dispatch(ctx, rejudge)
+		if outcome := rejudge.wait(t); outcome != "ack" {
+			t.Errorf("rejudge delivery %s, want ack", outcome)
+		}
+		if got := h.server.last(t); got.SubmissionId != 3 || got.Verdict != "ac" {
+			t.Errorf("rejudge verdict = %d/%s, want 3/ac", got.SubmissionId, got.Verdict)
+		}
+	})
+}
+
+func TestDispatchCancelsRunningJobs(t *testing.T) {
+	h := newHarness(t)
+	if err := h.scheduler.With(2); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 2)
+	finishFirst := make(chan struct{})
+	var mu sync.Mutex
+	runs := 0
+	h.sandbox.Script(func(r sandboxtest.Run) sandboxtest.Outcome {
+		mu.Lock()
+		runs++
+		first := runs == 1
+		mu.Unlock()
+		started <- struct{}{}
+
+		if first {
+			<-finishFirst
+			return sandboxtest.Accepted(string(r.Stdin))
+		}
+		select {
+		case <-r.Ctx.Done():
+			return sandboxtest.Outcome{Err: r.Ctx.Err()}
+		case <-time.After(5 * time.Second):
+			return sandboxtest.Accepted(string(r.Stdin))
+		}
+	})
+
+	// A duplicate delivery judges the same submission twice at once.
+	body, _ := json.Marshal(newSubmission(7, "fake", testcase("1\n", "1\n")))
+	var deliveries []*fakeDelivery
+	for range 2 {
+		d := newDelivery(body)
+		deliveries = append(deliveries, d)
+		h.scheduler.Dispatch(context.Background(), d)
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("job did not start")
+		}
+	}
+
+	// The first job finishing must not untrack the second.
+	close(finishFirst)
+	deliveries[0].wait(t)
+	if got := h.server.last(t).Verdict; got != "ac" {
+		t.Fatalf("first verdict = %s, want ac", got)
+	}
+
+	result := h.scheduler.Cancel([]int64{7})
+	if len(result.Running) != 1 || len(result.Pending) != 0 {
+		t.Fatalf("cancel = %+v, want the running job", result)
+	}
+	if outcome := deliveries[1].wait(t); outcome != "ack" {
+		t.Errorf("delivery %s, want ack", outcome)
+	}
+	if got := h.server.last(t); got.SubmissionId != 7 || got.Verdict != "cancelled" {
+		t.Errorf("verdict = %d/%s, want 7/cancelled", got.SubmissionId, got.Verdict)
+	}
+
+	// Nothing is left to cancel, so the id is remembered in case an older
+	// message for it is dequeued again.
+	if result := h.scheduler.Cancel([]int64{7}); len(result.Pending) != 1 {
+		t.Errorf("cancel after finishing = %+v, want pending", result)
+	}
+}
+
+func TestDispatchCompilesWhileBoxIsBusy(t *testing.T) {
+	h := newHarness(t)
+	marker := filepath.Join(t.TempDir(), "compiled")
+	if err := languages.Load([]config.Language{{Name: "compiled", SourceFile: "main.txt", Compile: []string{"touch", marker}, Run: []string{"./main"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	running := make(chan struct{}, 1)
+	finish := make(chan struct{})
+	h.sandbox.Script(func(r sandboxtest.Run) sandboxtest.Outcome {
+		if string(r.Stdin) == "slow\n" {
+			running <- struct{}{}
+			<-finish
+		}
+		return sandboxtest.Accepted(string(r.Stdin))
+	})
+
+	ctx := context.Background()
+	body, _ := json.Marshal(newSubmission(1, "compiled", testcase("slow\n", "slow\n")))
+	first := newDelivery(body)
+	h.scheduler.Dispatch(ctx, first)
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first submission never ran")
+	}
+	os.Remove(marker)
+
+	// The only box is busy, yet the next submission compiles.
+	body, _ = json.Marshal(newSubmission(2, "compiled", testcase("1\n", "1\n")))
+	second := newDelivery(body)
+	h.scheduler.Dispatch(ctx, second)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second submission did not compile while the box was busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(finish)
+	for _, d := range []*fakeDelivery{first, second} {
+		if outcome := d.wait(t); outcome != "ack" {
+			t.Errorf("delivery %s, want ack", outcome)
+		}
+	}
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+	for _, v := range h.server.verdicts {
+		if v.Verdict != "ac" {
+			t.Errorf("submission %d: verdict %s, want ac", v.SubmissionId, v.Verdict)
+		}
+	}
+}
+
+func TestSlotsResize(t *testing.T) {
+	s := newSlots(1)
+	ctx := context.Background()
+	if err := s.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(short); err == nil {
+		t.Fatal("acquired more slots than there are")
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- s.acquire(ctx) }()
+	s.resize(2)
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	// Shrinking waits for held slots to be released.
+	s.resize(1)
+	s.release()
+	short, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(short); err == nil {
+		t.Error("acquired a slot beyond the new size")
+	}
+	s.release()
+	if err := s.acquire(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPoolInitializesBoxesOutsideLock(t *testing.T) {
+	fake := sandboxtest.NewFake(t.TempDir())
+	p := newPool(fake, 0, 1, t.TempDir())
+	p.setPolicy(&config.Config{ResetAttempts: 1, ResetRetryDelay: time.Millisecond, QuarantineRetry: time.Millisecond})
+	if _, err := p.resize(1); err != nil {
+		t.Fatal(err)
+	}
+	defer p.shutdown(context.Background())
+
+	initializing := make(chan struct{})
+	proceed := make(chan struct{})
+	fake.FailInit(func(boxId int) error {
+		if boxId == 1 {
+			close(initializing)
+			<-proceed
+		}
+		return nil
+	})
+	grown := make(chan int)
+	go func() {
+		size, _ := p.resize(2)
+		grown <- size
+	}()
+	<-initializing
+
+	// A slow isolate --init holds up neither releases nor metrics.
+	done := make(chan struct{})
+	go func() {
+		p.release(p.take(1)[0])
+		p.stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("release blocked while a box was initializing")
+	}
+
+	close(proceed)
+	if size := <-grown; size != 2 {
+		t.Errorf("pool grew to %d, want 2", size)
+	}
+}
+
+func TestPoolSkipsBoxesThatFailToInit(t *testing.T) {
+	fake := sandboxtest.NewFake(t.TempDir())
+	fake.FailInit(func(boxId int) error {
+		if boxId == 0 {
+			return errors.New("box is wedged")
+		}
+		return nil
+	})
+	p := newPool(fake, 0, 2, t.TempDir())
+	p.setPolicy(&config.Config{ResetAttempts: 1, ResetRetryDelay: time.Millisecond, QuarantineRetry: time.Millisecond})
+	defer p.shutdown(context.Background())
+
+	if size, err := p.resize(2); err != nil || size != 2 {
+		t.Fatalf("resize = %d, %v, want 2 boxes", size, err)
+	}
+	if idle := p.take(2); len(idle) != 2 || idle[0].Id == 0 || idle[1].Id == 0 {
+		t.Errorf("idle boxes = %v, want boxes 1 and 2", idle)
+	}
+}
+
+func TestReloadFailureKeepsSettings(t *testing.T) {
+	h := newHarness(t)
+	cfg := *h.scheduler.Handler().Config
+	cfg.WorkerCount = 3
+	cfg.Languages = []config.Language{{Name: "bad"}} // no source_file
+
+	if err := h.scheduler.Reload(&cfg); err == nil {
+		t.Fatal("reload with an invalid language succeeded")
+	}
+	if size := h.scheduler.pool.size(); size != 1 {
+		t.Errorf("pool resized to %d by a failed reload", size)
+	}
+	if workers := h.scheduler.Handler().Config.WorkerCount; workers != 1 {
+		t.Errorf("worker_count %d applied by a failed reload", workers)
+	}
+	if languages.Lookup("fake") == nil {
+		t.Error("languages swapped by a failed reload")
+	}
+
+	// Box ids 0-3 cannot hold 5 workers.
+	cfg = *h.scheduler.Handler().Config
+	cfg.WorkerCount = 5
+	cfg.ParallelTests = 2
+	if err := h.scheduler.Reload(&cfg); err == nil {
+		t.Fatal("reload beyond the box id range succeeded")
+	}
+	if parallel := h.scheduler.Handler().Config.ParallelTests; parallel == 2 {
+		t.Errorf("parallel_tests %d applied by a failed reload", parallel)
+	}
+}
+
+func TestPoolQuarantinesBoxThatFailsToReset(t *testing.T) {
+	fake := sandboxtest.NewFake(t.TempDir())
+	p := newPool(fake, 0, 1, t.TempDir())
+	p.setPolicy(&config.Config{ResetAttempts: 2, ResetRetryDelay: time.Millisecond, QuarantineRetry: 20 * time.Millisecond})
+	if _, err := p.resize(2); err != nil {
+		t.Fatal(err)
+	}
+
+	var broken atomic.Bool
+	broken.Store(true)
+	fake.FailInit(func(boxId int) error {
+		if boxId == 0 && broken.Load() {
+			return errors.New("box is wedged")
+		}
+		return nil
+	})
+	for _, w := range p.take(2) {
+		p.release(w)
+	}
+
+	waitFor := func(what string, done func(PoolStats) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !done(p.stats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: stats = %+v", what, p.stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("box 0 quarantined", func(s PoolStats) bool { return s.Quarantined == 1 && s.Idle == 1 })
+	if idle := p.take(2); len(idle) != 1 || idle[0].Id != 1 {
+		t.Fatalf("idle boxes = %v, want only box 1", idle)
+	} else {
+		p.release(idle[0])
+	}
+
+	broken.Store(false)
+	waitFor("box 0 recovered", func(s PoolStats) bool { return s.Quarantined == 0 && s.Idle == 2 })
+	if failures := p.stats().ResetFailures; failures < 2 {
+		t.Errorf("%d reset failures counted, want at least 2", failures)
+	}
+}
+
+func TestPoolShutdownWaitsForResets(t *testing.T) {
+	fake := sandboxtest.NewFake(t.TempDir())
+	p := newPool(fake, 0, 1, t.TempDir())
+	p.setPolicy(&config.Config{ResetAttempts: 1, ResetRetryDelay: time.Hour, QuarantineRetry: time.Hour})
+	if _, err := p.resize(2); err != nil {
+		t.Fatal(err)
+	}
+
+	proceed := make(chan struct{})
+	fake.FailInit(func(boxId int) error {
+		if boxId == 0 {
+			return errors.New("box is wedged")
+		}
+		<-proceed
+		return nil
+	})
+	for _, w := range p.take(2) {
+		p.release(w)
+	}
+
+	// Box 0 waits an hour in quarantine; box 1 is still being reset.
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.shutdown(short); err == nil {
+		t.Fatal("shutdown did not wait for the reset under way")
+	}
+	close(proceed)
+	done := make(chan error)
+	go func() { done <- p.shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown waited for the quarantined box")
+	}
+	if stats := p.stats(); stats.Idle != 1 || stats.Busy != 0 {
+		t.Errorf("stats = %+v, want box 1 idle", stats)
+	}
+}
+
+func TestValidateTestcaseReferences(t *testing.T) {
+	h := newHarness(t)
+	cfg := *h.scheduler.Handler().Config
+	sum := strings.Repeat("a", 64)
+	id := int64(7)
+
+	tests := []struct {
+		name       string
+		submission func(*structs.Submission)
+		endpoint   string
+		fields     []string
+	}{
+		{"reference", func(s *structs.Submission) {
+			s.Testcases = []structs.Testcase{{InputSha256: sum, ExpectedOutputSha256: sum}}
+		}, "http://server", nil},
+		{"problem", func(s *structs.Submission) { s.Testcases, s.ProblemId, s.ProblemVersion = nil, &id, "v1" }, "http://server", nil},
+		{"no endpoint", func(s *structs.Submission) { s.Testcases = []structs.Testcase{{InputSha256: sum}} }, "", []string{"testcases"}},
+		{"bad checksum", func(s *structs.Submission) { s.Testcases = []structs.Testcase{{InputSha256: "ABC"}} }, "http://server", []string{"testcases[0].input_sha256"}},
+		{"both inline and reference", func(s *structs.Submission) { s.Testcases = []structs.Testcase{{Input: "1", InputSha256: sum}} }, "http://server", []string{"testcases[0].input"}},
+		{"problem and testcases", func(s *structs.Submission) { s.ProblemId, s.ProblemVersion = &id, "v1" }, "http://server", []string{"problem_id"}},
+		{"bad version", func(s *structs.Submission) { s.Testcases, s.ProblemId, s.ProblemVersion = nil, &id, "../v1" }, "http://server", []string{"problem_version"}},
+		{"nothing", func(s *structs.Submission) { s.Testcases = nil }, "", []string{"testcases"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			submission := newSubmission(1, "fake", testcase("1\n", "1\n"))
+			tt.submission(submission)
+			cfg.TestcaseEndpoint = tt.endpoint
+
+			var fields []string
+			for _, e := range ValidateSubmission(submission, &cfg, true) {
+				fields = append(fields, e.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// slots limits how many jobs do something at once. Unlike a buffered
+// channel it can be resized while slots are held: shrinking only takes
+// effect as they are released.
+type slots struct {
+	mu    sync.Mutex
+	size  int
+	used  int
+	freed chan struct{} // closed and replaced whenever a slot may be free
+}
+
+func newSlots(size int) *slots {
+	return &slots{size: size, freed: make(chan struct{})}
+}
+
+// acquire waits for a free slot until ctx is done. A done ctx gets no slot
+// even if one is free.
+func (s *slots) acquire(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.used < s.size {
+			s.used++
+			s.mu.Unlock()
+			return nil
+		}
+		freed := s.freed
+		s.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used--
+	s.wakeLocked()
+}
+
+func (s *slots) resize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.wakeLocked()
+}
+
+func (s *slots) wakeLocked() {
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/handlers"
+	"github.com/judgenot0/judge-deamon/structs"
+	"github.com/judgenot0/judge-deamon/testcases"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a submission.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *ValidationError) add(field, format string, args ...any) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateSubmission checks a submission against the configured limits. It
+// returns nil when the submission can be judged. requireId is false for
+// /run requests, which are answered directly instead of reported by id.
+func ValidateSubmission(submission *structs.Submission, cfg *config.Config, requireId bool) ValidationError {
+	var errs ValidationError
+
+	if requireId && submission.SubmissionId == nil {
+		errs.add("submission_id", "is required")
+	}
+
+	if submission.Language == "" {
+		errs.add("language", "is required")
+	} else if GetRunner(submission.Language) == nil {
+		errs.add("language", "unsupported language %q", submission.Language)
+	}
+
+	if submission.SourceCode == "" {
+		errs.add("source_code", "is required")
+	} else if len(submission.SourceCode) > cfg.MaxSourceSize {
+		errs.add("source_code", "is %d bytes, limit is %d", len(submission.SourceCode), cfg.MaxSourceSize)
+	}
+
+	if submission.TimeLimit <= 0 {
+		errs.add("time_limit", "must be positive")
+	} else if submission.TimeLimit > cfg.MaxTimeLimit {
+		errs.add("time_limit", "must be at most %v seconds", cfg.MaxTimeLimit)
+	}
+
+	if submission.MemoryLimit <= 0 {
+		errs.add("memory_limit", "must be positive")
+	} else if submission.MemoryLimit > cfg.MaxMemoryLimit {
+		errs.add("memory_limit", "must be at most %v MB", cfg.MaxMemoryLimit)
+	}
+
+	if submission.OutputLimit < 0 {
+		errs.add("output_limit", "must not be negative")
+	} else if submission.OutputLimit > cfg.MaxOutputLimit {
+		errs.add("output_limit", "must be at most %v MB", cfg.MaxOutputLimit)
+	}
+
+	if submission.ProblemId != nil {
+		if len(submission.Testcases) > 0 {
+			errs.add("problem_id", "must not be combined with testcases")
+		}
+		if !testcases.ValidVersion(submission.ProblemVersion) {
+			errs.add("problem_version", "must be 1-64 letters, digits, dots, dashes or underscores, got %q", submission.ProblemVersion)
+		}
+	} else if len(submission.Testcases) == 0 {
+		errs.add("testcases", "at least one testcase or a problem_id is required")
+	}
+	for i, test := range submission.Testcases {
+		validateTestcaseFile(&errs, cfg, fmt.Sprintf("testcases[%d].input", i), test.Input, test.InputSha256)
+		validateTestcaseFile(&errs, cfg, fmt.Sprintf("testcases[%d].expected_output", i), test.ExpectedOutput, test.ExpectedOutputSha256)
+	}
+	if testcases.References(submission) && cfg.TestcaseEndpoint == "" {
+		errs.add("testcases", "referenced testcases need the engine's testcase_endpoint")
+	}
+
+	if !handlers.KnownChecker(submission.CheckerType) {
+		errs.add("checker_type", "unknown checker %q", submission.CheckerType)
+	}
+	if submission.CheckerType == "custom" {
+		if _, err := handlers.CheckerPath(cfg.CheckerDir, submission.CheckerName); err != nil {
+			errs.add("checker_name", "%v", err)
+		}
+	}
+	if submission.CheckerPrecision != nil {
+		if _, ok := handlers.ParsePrecision(*submission.CheckerPrecision); !ok {
+			errs.add("checker_precision", "must be a positive number, got %q", *submission.CheckerPrecision)
+		}
+	}
+	if _, ok := handlers.FloatModes[submission.CheckerFloatMode]; !ok {
+		errs.add("checker_float_mode", "must be absolute, relative or absolute_or_relative, got %q", submission.CheckerFloatMode)
+	}
+	if e := submission.CheckerAbsoluteError; e != nil && !handlers.ValidTolerance(*e) {
+		errs.add("checker_absolute_error", "must be a positive number, got %v", *e)
+	}
+	if e := submission.CheckerRelativeError; e != nil && !handlers.ValidTolerance(*e) {
+		errs.add("checker_relative_error", "must be a positive number, got %v", *e)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateTestcaseFile checks one testcase file, given either inline or by
+// checksum.
+func validateTestcaseFile(errs *ValidationError, cfg *config.Config, field, inline, sum string) {
+	if sum == "" {
+		if len(inline) > cfg.MaxTestcaseSize {
+			errs.add(field, "is %d bytes, limit is %d", len(inline), cfg.MaxTestcaseSize)
+		}
+		return
+	}
+	if !testcases.ValidChecksum(sum) {
+		errs.add(field+"_sha256", "must be a lowercase hex SHA-256, got %q", sum)
+	}
+	if inline != "" {
+		errs.add(field, "must be empty when the checksum is given")
+	}
+}
@@ -1,8 +1,16 @@
 package structs
 
+// Testcase holds its files inline, or references them by SHA-256 to be
+// fetched into the engine's testcase cache.
 type Testcase struct {
-	Input          string `json:"input" db:"input"`
-	ExpectedOutput string `json:"expected_output" db:"expected_output"`
+	Input                string `json:"input" db:"input"`
+	ExpectedOutput       string `json:"expected_output" db:"expected_output"`
+	InputSha256          string `json:"input_sha256,omitempty"`
+	ExpectedOutputSha256 string `json:"expected_output_sha256,omitempty"`
+
+	// Cached files of referenced tests, set by the testcase store.
+	InputPath          string `json:"-"`
+	ExpectedOutputPath string `json:"-"`
 }
 
 type Submission struct {
@@ -12,7 +20,30 @@ type Submission struct {
 	Testcases          []Testcase `json:"testcases"`
 	TimeLimit          float32    `json:"time_limit"`
 	MemoryLimit        float32    `json:"memory_limit"`
+	OutputLimit        float32    `json:"output_limit"` // megabytes per output file; zero uses the engine default
 	CheckerType        string     `json:"checker_type"`
 	CheckerStrictSpace bool       `json:"checker_strict_space"`
 	CheckerPrecision   *string    `json:"checker_precision"`
+	// CheckerFloatMode selects how the float checker compares numbers:
+	// "absolute", "relative" or "absolute_or_relative". Empty keeps the
+	// original rule, where precision scales with the larger magnitude.
+	CheckerFloatMode     string   `json:"checker_float_mode"`
+	CheckerAbsoluteError *float64 `json:"checker_absolute_error"`
+	CheckerRelativeError *float64 `json:"checker_relative_error"`
+	// CheckerPresentationError reports "pe" instead of "wa" when the output
+	// only differs from the expected one in whitespace.
+	CheckerPresentationError bool `json:"checker_presentation_error"`
+	// CheckerDiagnostics adds the first difference to "wa" and "pe" verdicts.
+	// It reveals part of the expected output, so leave it off in contests.
+	CheckerDiagnostics bool `json:"checker_diagnostics"`
+	// CheckerName is the executable in the engine's checker_dir used when
+	// CheckerType is "custom".
+	CheckerName string `json:"checker_name"`
+	// PartialScoring runs every test and reports the mean test score.
+	PartialScoring bool `json:"partial_scoring"`
+
+	// ProblemId and ProblemVersion stand for the problem's testcases when
+	// Testcases is empty; the list is fetched from the server once.
+	ProblemId      *int64 `json:"problem_id,omitempty"`
+	ProblemVersion string `json:"problem_version,omitempty"`
 }
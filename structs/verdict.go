@@ -5,4 +5,47 @@ type Verdict struct {
 	Result     string
 	MaxTime    *float32
 	MaxRSS     *float32
+	// RuntimeError describes the failing test when Result is "re".
+	RuntimeError *RuntimeError
+	// WrongAnswer locates the first difference when Result is "wa" or "pe"
+	// and the submission asked for checker diagnostics.
+	WrongAnswer *WrongAnswer
+	// Score is the mean of the test scores, in [0, 1], when the submission
+	// asked for partial scoring.
+	Score *float64
+	Tests []TestResult
+}
+
+// TestResult is the outcome of one testcase. Unless the submission asked
+// for partial scoring, tests after the first failure are not run and have no
+// result.
+type TestResult struct {
+	Index        int           `json:"index"` // position in Submission.Testcases
+	Result       string        `json:"verdict"`
+	Time         float32       `json:"time"`              // seconds
+	Memory       float32       `json:"memory"`            // kilobytes
+	Score        float64       `json:"score"`             // in [0, 1]
+	Message      string        `json:"message,omitempty"` // from the checker
+	RuntimeError *RuntimeError `json:"runtime_error,omitempty"`
+	WrongAnswer  *WrongAnswer  `json:"wrong_answer,omitempty"`
+}
+
+type RuntimeError struct {
+	Signal   string `json:"signal,omitempty"` // e.g. SIGSEGV, when killed by a signal
+	ExitCode int    `json:"exit_code,omitempty"`
+	Message  string `json:"message,omitempty"` // as reported by the sandbox
+	Hint     string `json:"hint,omitempty"`    // likely cause, for the user
+}
+
+type WrongAnswer struct {
+	Line         int    `json:"line"`          // in the output, 1-based
+	ExpectedLine int    `json:"expected_line"` // in the expected output
+	Column       int    `json:"column,omitempty"`
+	Token        int    `json:"token,omitempty"`
+	Output       string `json:"output"`   // truncated excerpt at the difference
+	Expected     string `json:"expected"` // truncated excerpt at the difference
+	// Reason is one of different, extra_output, missing_output, token_count
+	// and outside_tolerance.
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
 }
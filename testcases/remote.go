@@ -0,0 +1,191 @@
+package testcases
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+// remote is the server's testcase API:
+//
+//	GET <endpoint>/files/<sha256>                        the file itself
+//	GET <endpoint>/problems/<id>/versions/<version>      {"testcases": [{"input_sha256": ..., "expected_output_sha256": ...}]}
+//
+// Both authenticate with the engine key as a bearer token.
+type remote struct {
+	endpoint string
+	key      string
+	timeout  time.Duration
+	maxSize  int64
+	client   *http.Client
+}
+
+func newRemote(cfg *config.Config) *remote {
+	return &remote{
+		endpoint: cfg.TestcaseEndpoint,
+		key:      cfg.EngineKey,
+		timeout:  cfg.TestcaseFetchTimeout,
+		maxSize:  int64(cfg.MaxTestcaseSize),
+		client:   http.DefaultClient,
+	}
+}
+
+func (r *remote) get(ctx context.Context, path string) (*http.Response, error) {
+	if r.endpoint == "" {
+		return nil, errors.New("testcase_endpoint is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.key)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// download fetches the file with checksum sum into the cache and returns
+// its size. The file only appears under its final name once verified.
+func (s *Store) download(ctx context.Context, remote *remote, sum string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, remote.timeout)
+	defer cancel()
+
+	resp, err := remote.get(ctx, "/files/"+sum)
+	if err != nil {
+		return 0, fmt.Errorf("fetching %s: %w", sum, err)
+	}
+	defer resp.Body.Close()
+
+	path := s.path(sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, remote.maxSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("fetching %s: %w", sum, err)
+	}
+	if size > remote.maxSize {
+		return 0, fmt.Errorf("fetching %s: larger than max_testcase_size", sum)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != sum {
+		return 0, fmt.Errorf("fetching %s: checksum mismatch, got %s", sum, got)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+// ValidVersion reports whether version can name a problem version.
+func ValidVersion(version string) bool {
+	return versionPattern.MatchString(version)
+}
+
+type manifest struct {
+	Testcases []structs.Testcase `json:"testcases"`
+}
+
+// manifest returns the testcases of a problem version. Versions never
+// change, so the list is fetched once and kept with the cache.
+func (s *Store) manifest(ctx context.Context, remote *remote, problemId int64, version string) ([]structs.Testcase, error) {
+	if !ValidVersion(version) {
+		return nil, fmt.Errorf("invalid problem version %q", version)
+	}
+	path := filepath.Join(s.dir, "problems", strconv.FormatInt(problemId, 10), version+".json")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = s.fetchManifest(ctx, remote, problemId, version, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem %d version %s: %w", problemId, version, err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("problem %d version %s: %w", problemId, version, err)
+	}
+	if len(m.Testcases) == 0 {
+		return nil, fmt.Errorf("problem %d version %s has no testcases", problemId, version)
+	}
+	tests := make([]structs.Testcase, len(m.Testcases))
+	for i, test := range m.Testcases {
+		if !ValidChecksum(test.InputSha256) || !ValidChecksum(test.ExpectedOutputSha256) {
+			return nil, fmt.Errorf("problem %d version %s: testcase %d lacks valid checksums", problemId, version, i)
+		}
+		tests[i] = structs.Testcase{InputSha256: test.InputSha256, ExpectedOutputSha256: test.ExpectedOutputSha256}
+	}
+	return tests, nil
+}
+
+func (s *Store) fetchManifest(ctx context.Context, remote *remote, problemId int64, version, path string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remote.timeout)
+	defer cancel()
+
+	resp, err := remote.get(ctx, fmt.Sprintf("/problems/%d/versions/%s", problemId, version))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &manifest{}); err != nil {
+		return nil, fmt.Errorf("parsing testcase list: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	return data, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package testcases
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+const (
+	stagingDir = "staging"
+	// InputFile is the name of the current test's input in Stage.InputDir.
+	InputFile = "in.txt"
+)
+
+// Stage holds the files of the test a job is running, outside its box. The
+// input directory is mounted read-only into the box; the expected output
+// sits beside it where the program cannot see it. Cached files are
+// hard-linked in rather than copied, and inline ones are written once.
+type Stage struct {
+	dir string
+}
+
+// NewStage creates a stage for one job. With a cache it lives in the
+// cache directory, so cached files can be linked on the same filesystem.
+// Close removes it.
+func (s *Store) NewStage() (*Stage, error) {
+	parent := os.TempDir()
+	if s != nil {
+		parent = filepath.Join(s.dir, stagingDir)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return nil, fmt.Errorf("creating staging directory: %w", err)
+		}
+	}
+	dir, err := os.MkdirTemp(parent, "job-")
+	if err != nil {
+		return nil, fmt.Errorf("creating staging directory: %w", err)
+	}
+	st := &Stage{dir: dir}
+	// The box's user reads the input directory through the mount.
+	if err := os.Mkdir(st.InputDir(), 0755); err != nil {
+		st.Close()
+		return nil, fmt.Errorf("creating staging directory: %w", err)
+	}
+	if err := os.Chmod(st.InputDir(), 0755); err != nil {
+		st.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// InputDir is the directory to mount into the box.
+func (st *Stage) InputDir() string {
+	return filepath.Join(st.dir, "input")
+}
+
+// Put stages test, replacing the previous one, and returns the host paths
+// of its input and expected output.
+func (st *Stage) Put(test *structs.Testcase) (input, expected string, err error) {
+	input = filepath.Join(st.InputDir(), InputFile)
+	if err := place(input, test.InputPath, test.Input); err != nil {
+		return "", "", fmt.Errorf("staging input: %w", err)
+	}
+	if test.ExpectedOutputPath != "" {
+		// Read only by the engine, so the cached file itself will do.
+		return input, test.ExpectedOutputPath, nil
+	}
+	expected = filepath.Join(st.dir, "expected.txt")
+	if err := place(expected, "", test.ExpectedOutput); err != nil {
+		return "", "", fmt.Errorf("staging expected output: %w", err)
+	}
+	return input, expected, nil
+}
+
+func (st *Stage) Close() error {
+	return os.RemoveAll(st.dir)
+}
+
+// place makes path hold the file at source, or content when source is
+// empty. A file that cannot be linked, e.g. across filesystems, is copied.
+func place(path, source, content string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if source != "" && os.Link(source, path) == nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = strings.NewReader(content)
+	if source != "" {
+		src, err := os.Open(source)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		defer src.Close()
+		r = src
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
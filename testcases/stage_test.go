@@ -0,0 +1,66 @@
+package testcases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+func TestStageLinksCachedFiles(t *testing.T) {
+	server := newFileServer(t, "1 2\n", "3\n")
+	dir := t.TempDir()
+	store, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submission := &structs.Submission{Testcases: []structs.Testcase{
+		reference("1 2\n", "3\n"),
+		{Input: "inline\n", ExpectedOutput: "out\n"},
+	}}
+	release, err := store.Resolve(context.Background(), server.config(), submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	stage, err := store.NewStage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, expected, err := stage.Put(&submission.Testcases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, _ := os.Stat(input)
+	cached, _ := os.Stat(submission.Testcases[0].InputPath)
+	if staged == nil || cached == nil || !os.SameFile(staged, cached) {
+		t.Error("cached input was not linked into the stage")
+	}
+	if expected != submission.Testcases[0].ExpectedOutputPath {
+		t.Errorf("expected output staged at %s, want the cached file", expected)
+	}
+
+	input, expected, err = stage.Put(&submission.Testcases[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{input: "inline\n", expected: "out\n"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+	if filepath.Dir(input) != stage.InputDir() || filepath.Dir(expected) == stage.InputDir() {
+		t.Errorf("input at %s and expected output at %s, want only the input in %s", input, expected, stage.InputDir())
+	}
+
+	// A stage left by a crash is removed on the next start.
+	if _, err := New(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stage.InputDir()); !os.IsNotExist(err) {
+		t.Errorf("stale stage kept: %v", err)
+	}
+}
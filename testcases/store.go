@@ -0,0 +1,267 @@
+// Package testcases keeps testcase files referenced by SHA-256 in a local
+// cache, fetching missing ones from the server. Files are stored under
+// their checksum, verified on download and on start, and evicted least
+// recently used first once the cache outgrows its budget. Files used by a
+// running job are never evicted.
+package testcases
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/sandbox"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+const tempPrefix = ".tmp-"
+
+type Store struct {
+	dir    string
+	budget int64 // bytes
+
+	mu       sync.Mutex
+	entries  map[string]*entry // by checksum
+	size     int64
+	fetching map[string]chan struct{}
+}
+
+type entry struct {
+	size int64
+	used time.Time
+	pins int
+}
+
+// New opens the cache in dir, indexing the files already there by their
+// modification time, which is refreshed whenever a file is used. Files are
+// hashed again first, and those that no longer match their name deleted.
+func New(dir string, budgetMB int) (*Store, error) {
+	s := &Store{
+		dir:      dir,
+		budget:   int64(budgetMB) << 20,
+		entries:  make(map[string]*entry),
+		fetching: make(map[string]chan struct{}),
+	}
+	if err := sandbox.PrivateDir(dir); err != nil {
+		return nil, fmt.Errorf("creating testcase cache: %w", err)
+	}
+	// Stages of jobs interrupted by a restart.
+	if err := os.RemoveAll(filepath.Join(dir, stagingDir)); err != nil {
+		return nil, fmt.Errorf("clearing staging directory: %w", err)
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, tempPrefix) {
+			// left over from an interrupted download
+			return os.Remove(path)
+		}
+		if !ValidChecksum(name) || path != s.path(name) || !d.Type().IsRegular() {
+			return nil
+		}
+		if sum, err := fileChecksum(path); err != nil || sum != name {
+			log.Printf("Warning: removing testcase %s, which does not match its checksum", name)
+			return os.Remove(path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s.entries[name] = &entry{size: info.Size(), used: info.ModTime()}
+		s.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("indexing testcase cache: %w", err)
+	}
+
+	s.mu.Lock()
+	s.evictLocked()
+	s.mu.Unlock()
+	log.Printf("Testcase cache %s: %d files, %d MB", dir, len(s.entries), s.size>>20)
+	return s, nil
+}
+
+// ValidChecksum reports whether sum is a lowercase hex SHA-256.
+func ValidChecksum(sum string) bool {
+	if len(sum) != 64 {
+		return false
+	}
+	for i := 0; i < len(sum); i++ {
+		c := sum[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *Store) path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+// Resolve makes the files of a submission's referenced testcases available
+// and sets their paths. A submission naming a problem instead of listing
+// testcases gets the problem's list first. Inline testcases are left as
+// they are. release unpins the files once the job is done with them.
+func (s *Store) Resolve(ctx context.Context, cfg *config.Config, submission *structs.Submission) (release func(), err error) {
+	if !References(submission) {
+		return func() {}, nil
+	}
+	if s == nil {
+		return nil, errors.New("testcase cache is not configured")
+	}
+	remote := newRemote(cfg)
+
+	if len(submission.Testcases) == 0 {
+		tests, err := s.manifest(ctx, remote, *submission.ProblemId, submission.ProblemVersion)
+		if err != nil {
+			return nil, err
+		}
+		submission.Testcases = tests
+	}
+
+	var pinned []string
+	release = func() {
+		s.unpin(pinned)
+	}
+	for i := range submission.Testcases {
+		test := &submission.Testcases[i]
+		for _, file := range []struct {
+			sum  string
+			path *string
+		}{
+			{test.InputSha256, &test.InputPath},
+			{test.ExpectedOutputSha256, &test.ExpectedOutputPath},
+		} {
+			if file.sum == "" {
+				continue
+			}
+			if err := s.acquire(ctx, remote, file.sum); err != nil {
+				release()
+				return nil, fmt.Errorf("testcase %d: %w", i, err)
+			}
+			pinned = append(pinned, file.sum)
+			*file.path = s.path(file.sum)
+		}
+	}
+	return release, nil
+}
+
+// References reports whether a submission needs the testcase cache.
+func References(submission *structs.Submission) bool {
+	if len(submission.Testcases) == 0 && submission.ProblemId != nil {
+		return true
+	}
+	for _, test := range submission.Testcases {
+		if test.InputSha256 != "" || test.ExpectedOutputSha256 != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire pins the file with checksum sum, downloading it unless it is
+// cached. Concurrent requests for the same file share one download.
+func (s *Store) acquire(ctx context.Context, remote *remote, sum string) error {
+	for {
+		s.mu.Lock()
+		if e := s.entries[sum]; e != nil {
+			e.pins++
+			e.used = time.Now()
+			s.mu.Unlock()
+			// Persist the recency for the index built after a restart.
+			now := time.Now()
+			os.Chtimes(s.path(sum), now, now)
+			return nil
+		}
+		if done, ok := s.fetching[sum]; ok {
+			s.mu.Unlock()
+			select {
+			case <-done:
+				// Try again: the download may have failed for a reason of
+				// its own, like a cancelled job.
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		s.fetching[sum] = done
+		s.mu.Unlock()
+
+		size, err := s.download(ctx, remote, sum)
+
+		s.mu.Lock()
+		delete(s.fetching, sum)
+		close(done)
+		if err == nil {
+			s.entries[sum] = &entry{size: size, used: time.Now(), pins: 1}
+			s.size += size
+			s.evictLocked()
+		}
+		s.mu.Unlock()
+		return err
+	}
+}
+
+func (s *Store) unpin(sums []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sum := range sums {
+		if e := s.entries[sum]; e != nil && e.pins > 0 {
+			e.pins--
+		}
+	}
+	s.evictLocked()
+}
+
+// evictLocked removes the least recently used unpinned files until the
+// cache fits its budget.
+func (s *Store) evictLocked() {
+	for s.size > s.budget {
+		var oldest string
+		for sum, e := range s.entries {
+			if e.pins == 0 && (oldest == "" || e.used.Before(s.entries[oldest].used)) {
+				oldest = sum
+			}
+		}
+		if oldest == "" {
+			log.Printf("Warning: testcase cache is %d MB over its budget with every file in use", (s.size-s.budget)>>20)
+			return
+		}
+		if err := os.Remove(s.path(oldest)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error evicting testcase %s: %v", oldest, err)
+			return
+		}
+		s.size -= s.entries[oldest].size
+		delete(s.entries, oldest)
+	}
+}
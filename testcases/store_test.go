@@ -0,0 +1,306 @@
+package testcases
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/judgenot0/judge-deamon/config"
+	"github.com/judgenot0/judge-deamon/structs"
+)
+
+const testKey = "secret"
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// fileServer serves files by checksum and counts the requests for each.
+type fileServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	files     map[string]string
+	manifests map[string][]structs.Testcase
+	requests  map[string]int
+}
+
+func newFileServer(t *testing.T, contents ...string) *fileServer {
+	s := &fileServer{
+		files:     make(map[string]string),
+		manifests: make(map[string][]structs.Testcase),
+		requests:  make(map[string]int),
+	}
+	for _, c := range contents {
+		s.files[checksum(c)] = c
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+
+		if sum, ok := strings.CutPrefix(r.URL.Path, "/files/"); ok {
+			data, ok := s.files[sum]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(data))
+			return
+		}
+		if tests, ok := s.manifests[r.URL.Path]; ok {
+			json.NewEncoder(w).Encode(manifest{Testcases: tests})
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fileServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *fileServer) config() *config.Config {
+	return &config.Config{
+		TestcaseEndpoint:     s.URL,
+		EngineKey:            testKey,
+		TestcaseFetchTimeout: 5 * time.Second,
+		MaxTestcaseSize:      1024,
+	}
+}
+
+func reference(input, expected string) structs.Testcase {
+	return structs.Testcase{InputSha256: checksum(input), ExpectedOutputSha256: checksum(expected)}
+}
+
+func TestResolveFetchesAndCaches(t *testing.T) {
+	server := newFileServer(t, "1 2\n", "3\n")
+	store, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		submission := &structs.Submission{Testcases: []structs.Testcase{
+			reference("1 2\n", "3\n"),
+			{Input: "inline\n", ExpectedOutput: "inline\n"},
+		}}
+		release, err := store.Resolve(context.Background(), server.config(), submission)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(submission.Testcases[0].ExpectedOutputPath)
+		if err != nil || string(data) != "3\n" {
+			t.Errorf("expected output = %q, %v", data, err)
+		}
+		if submission.Testcases[1].InputPath != "" {
+			t.Error("inline testcase was given a cached file")
+		}
+		release()
+	}
+
+	if n := server.count("/files/" + checksum("1 2\n")); n != 1 {
+		t.Errorf("input fetched %d times, want once", n)
+	}
+}
+
+func TestResolveRejectsBadFiles(t *testing.T) {
+	server := newFileServer(t)
+	tampered := checksum("real")
+	server.files[tampered] = "tampered"
+	large := strings.Repeat("x", 2048)
+	server.files[checksum(large)] = large
+
+	store, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sum := range map[string]string{"tampered": tampered, "missing": checksum("missing"), "too large": checksum(large)} {
+		submission := &structs.Submission{Testcases: []structs.Testcase{{InputSha256: sum}}}
+		if _, err := store.Resolve(context.Background(), server.config(), submission); err == nil {
+			t.Errorf("%s file was accepted", name)
+		}
+	}
+	if len(store.entries) != 0 || store.size != 0 {
+		t.Errorf("cache holds %d files after failed fetches", len(store.entries))
+	}
+}
+
+// resolveFile pins one file through Resolve and returns its path.
+func resolveFile(t *testing.T, store *Store, cfg *config.Config, content string) (string, func()) {
+	t.Helper()
+	submission := &structs.Submission{Testcases: []structs.Testcase{{InputSha256: checksum(content)}}}
+	release, err := store.Resolve(context.Background(), cfg, submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return submission.Testcases[0].InputPath, release
+}
+
+func TestNewChecksCachedFiles(t *testing.T) {
+	dir := t.TempDir()
+	good, planted := checksum("good\n"), checksum("expected\n")
+	for sum, content := range map[string]string{good: "good\n", planted: "planted\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, sum[:2]), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sum[:2], sum), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.entries[good] == nil || store.entries[planted] != nil {
+		t.Errorf("indexed %v, want only the file matching its checksum", store.entries)
+	}
+	if _, err := os.Stat(store.path(planted)); !os.IsNotExist(err) {
+		t.Errorf("mismatched file kept: %v", err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("cache directory = %v, %v, want mode 0700", info, err)
+	}
+}
+
+func TestNewRefusesForeignDirectory(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing a directory's owner needs root")
+	}
+	dir := t.TempDir()
+	if err := os.Chown(dir, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir, 1); err == nil {
+		t.Error("opened a cache directory owned by another user")
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	a, b, c := strings.Repeat("a", 400<<10), strings.Repeat("b", 400<<10), strings.Repeat("c", 400<<10)
+	server := newFileServer(t, a, b, c)
+	cfg := server.config()
+	cfg.MaxTestcaseSize = 1 << 20
+	dir := t.TempDir()
+	store, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathA, release := resolveFile(t, store, cfg, a)
+	release()
+	pathB, release := resolveFile(t, store, cfg, b)
+	release()
+	_, release = resolveFile(t, store, cfg, a) // a is now more recent than b
+	release()
+	pathC, release := resolveFile(t, store, cfg, c)
+	release()
+
+	for path, want := range map[string]bool{pathA: true, pathB: false, pathC: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s kept = %v, want %v", path, err == nil, want)
+		}
+	}
+
+	reopened, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.entries) != 2 || reopened.size != int64(len(a)+len(c)) {
+		t.Errorf("reopened cache has %d files, %d bytes", len(reopened.entries), reopened.size)
+	}
+}
+
+func TestEvictionKeepsPinnedFiles(t *testing.T) {
+	a, b := strings.Repeat("a", 600<<10), strings.Repeat("b", 600<<10)
+	server := newFileServer(t, a, b)
+	cfg := server.config()
+	cfg.MaxTestcaseSize = 1 << 20
+	store, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathA, releaseA := resolveFile(t, store, cfg, a)
+	pathB, releaseB := resolveFile(t, store, cfg, b)
+	// Over budget, but both files are in use.
+	for _, path := range []string{pathA, pathB} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("pinned file evicted: %v", err)
+		}
+	}
+
+	releaseA()
+	if _, err := os.Stat(pathA); err == nil {
+		t.Error("released file kept over budget")
+	}
+	releaseB()
+	if _, err := os.Stat(pathB); err != nil {
+		t.Errorf("file within budget evicted: %v", err)
+	}
+}
+
+func TestResolveProblemVersion(t *testing.T) {
+	server := newFileServer(t, "1\n", "2\n")
+	server.manifests["/problems/7/versions/v3"] = []structs.Testcase{reference("1\n", "2\n")}
+	store, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		id := int64(7)
+		submission := &structs.Submission{ProblemId: &id, ProblemVersion: "v3"}
+		release, err := store.Resolve(context.Background(), server.config(), submission)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(submission.Testcases) != 1 || submission.Testcases[0].InputPath == "" {
+			t.Fatalf("testcases = %+v", submission.Testcases)
+		}
+		release()
+	}
+	if n := server.count("/problems/7/versions/v3"); n != 1 {
+		t.Errorf("testcase list fetched %d times, want once", n)
+	}
+
+	id := int64(8)
+	if _, err := store.Resolve(context.Background(), server.config(), &structs.Submission{ProblemId: &id, ProblemVersion: "v1"}); err == nil {
+		t.Error("unknown problem resolved")
+	}
+}
+
+func TestResolveWithoutReferences(t *testing.T) {
+	var store *Store
+	release, err := store.Resolve(context.Background(), &config.Config{}, &structs.Submission{Testcases: []structs.Testcase{{Input: "1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestValidVersion(t *testing.T) {
+	for version, want := range map[string]bool{"v3": true, "2024-01-01.1": true, "": false, "..": false, ".hidden": false, "a/b": false} {
+		if got := ValidVersion(version); got != want {
+			t.Errorf("ValidVersion(%q) = %v, want %v", version, got, want)
+		}
+	}
+}